@@ -4,86 +4,290 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 )
 
-// CachingTokenSource implements a TokenSource that writes its token to
-// disk on a successful fetch.
-type CachingTokenSource struct {
-	oauth2.TokenSource
-	Path string
+// defaultAuthTimeout is how long to wait for the user to complete an
+// interactive authorization.
+const defaultAuthTimeout = 5 * time.Minute
+
+// An authorizer is a service which is accessed through oauth2.
+type authorizer interface {
+	oauthConfig() *oauth2.Config
+	tokenStore(context.Context) (TokenStore, error)
 }
 
-func (cts *CachingTokenSource) Token() (*oauth2.Token, error) {
-	if token, err := cts.get(); errors.Is(err, os.ErrNotExist) {
-		token, err = cts.TokenSource.Token()
-		if err != nil {
-			return nil, err
-		}
-		err = cts.put(token)
-		return token, err
-	} else if err != nil {
-		return nil, err
-	} else {
-		return token, nil
+// The prefix of a token cache location which refers to an entry in the vault
+// rather than a file, e.g. "vault:splitwise_token".
+const vaultTokenPrefix = "vault:"
+
+// newTokenStore returns the store for the given token cache location.
+func newTokenStore(ctx context.Context, location string) (TokenStore, error) {
+	if !strings.HasPrefix(location, vaultTokenPrefix) {
+		return &FileTokenStore{Path: location}, nil
+	}
+	v := vaultFromContext(ctx)
+	if v == nil {
+		return nil, fmt.Errorf("token cache '%s' refers to the vault, but no vault is configured", location)
 	}
+	return &VaultTokenStore{
+		Vault: v,
+		Name:  strings.TrimPrefix(location, vaultTokenPrefix),
+	}, nil
+}
+
+// ErrReauthRequired is returned by a CachingTokenSource when there is no usable
+// token stored and it is not permitted to start an interactive authorization flow.
+var ErrReauthRequired = errors.New("re-authentication required")
+
+// A TokenStore persists an oauth2 token between runs.
+type TokenStore interface {
+	// Load the stored token. If no token has been stored the error
+	// satisfies errors.Is(err, os.ErrNotExist).
+	Load() (*oauth2.Token, error)
+	// Save replaces the stored token.
+	Save(*oauth2.Token) error
 }
 
-func (cts *CachingTokenSource) get() (*oauth2.Token, error) {
-	f, err := os.Open(cts.Path)
+// FileTokenStore implements a TokenStore that keeps the token as JSON in a
+// file readable only by the current user.
+type FileTokenStore struct {
+	Path string
+}
+
+func (fs *FileTokenStore) Load() (*oauth2.Token, error) {
+	f, err := os.Open(fs.Path)
 	if err != nil {
 		return nil, err
 	}
-	r := bufio.NewReader(f)
+	defer f.Close()
 
 	var token oauth2.Token
-	err = json.NewDecoder(r).Decode(&token)
-	return &token, err
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decode %s: %s", fs.Path, err)
+	}
+	return &token, nil
+}
+
+func (fs *FileTokenStore) Save(token *oauth2.Token) error {
+	return writeFileAtomic(fs.Path, 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(token)
+	})
 }
 
-func (cts *CachingTokenSource) put(token *oauth2.Token) error {
-	f, err := os.Create(cts.Path)
+// CachingTokenSource implements a TokenSource backed by a TokenStore.
+//
+// An expired token is refreshed using Config, and the store is updated whenever
+// the token changes. A new token is only requested from Authorize when there is
+// no stored token or it could not be refreshed.
+type CachingTokenSource struct {
+	// Name identifies the service in error messages, e.g. "splitwise".
+	Name string
+	// Store persists the token between runs.
+	Store TokenStore
+	// Config is used to refresh an expired token. If nil, tokens are never refreshed.
+	Config *oauth2.Config
+	// Authorize obtains a new token, typically through an interactive flow
+	// such as LocalServerTokenSource.
+	Authorize oauth2.TokenSource
+	// NonInteractive prevents Authorize from being used. ErrReauthRequired
+	// is returned instead.
+	NonInteractive bool
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func (cts *CachingTokenSource) Token() (*oauth2.Token, error) {
+	cts.mu.Lock()
+	defer cts.mu.Unlock()
+
+	if cts.token == nil {
+		token, err := cts.Store.Load()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("load token: %s", err)
+		}
+		cts.token = token
+	}
+	if cts.token.Valid() {
+		return cts.token, nil
+	}
+	if cts.token != nil && cts.token.RefreshToken != "" && cts.Config != nil {
+		log.Debug().Msg("refreshing expired token")
+		token, err := cts.Config.TokenSource(context.Background(), cts.token).Token()
+		if err == nil {
+			return token, cts.save(token)
+		}
+		log.Warn().Err(err).Msg("token refresh failed")
+	}
+	if cts.NonInteractive || cts.Authorize == nil {
+		return nil, fmt.Errorf("%w: no valid %s token is stored, run `budgetbridge auth %s`", ErrReauthRequired, cts.Name, cts.Name)
+	}
+	token, err := cts.Authorize.Token()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	w := bufio.NewWriter(f)
-	defer w.Flush()
+	return token, cts.save(token)
+}
 
-	encoder := json.NewEncoder(w)
-	return encoder.Encode(token)
+// save updates the in-memory token, writing it to the store if it has changed.
+func (cts *CachingTokenSource) save(token *oauth2.Token) error {
+	prev := cts.token
+	cts.token = token
+	if prev != nil &&
+		prev.AccessToken == token.AccessToken &&
+		prev.RefreshToken == token.RefreshToken &&
+		prev.Expiry.Equal(token.Expiry) {
+		return nil
+	}
+	log.Debug().Msg("storing updated token")
+	if err := cts.Store.Save(token); err != nil {
+		return fmt.Errorf("store token: %s", err)
+	}
+	return nil
 }
 
 // LocalServerTokenSource implements a TokenSource by starting a local server to
 // implement the standard oauth2 flow.
 type LocalServerTokenSource struct {
 	Config oauth2.Config
+	// Addr is the address to listen on for the callback. If empty, the port
+	// of Config.RedirectURL is used.
+	Addr string
+	// Timeout bounds how long to wait for the callback. Zero waits forever.
+	Timeout time.Duration
+	// PKCE enables Proof Key for Code Exchange (RFC 7636).
+	PKCE bool
 }
 
 func (p *LocalServerTokenSource) Token() (*oauth2.Token, error) {
 	ctx := context.Background()
-	state, err := newState()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	ar, err := newAuthRequest(p.PKCE)
 	if err != nil {
-		return nil, fmt.Errorf("generate csrf token: %s", err)
+		return nil, err
+	}
+	addr := p.Addr
+	if addr == "" {
+		if addr, err = callbackAddr(p.Config.RedirectURL); err != nil {
+			return nil, err
+		}
 	}
-	url := p.Config.AuthCodeURL(state, oauth2.AccessTypeOffline)
-	fmt.Printf("open this URL in the browser to authenticate.\n\n%s\n", url)
+	fmt.Printf("open this URL in the browser to authenticate.\n\n%s\n", ar.authCodeURL(&p.Config))
 
-	resp, err := waitForCallback(":4000", state)
+	resp, err := waitForCallback(ctx, addr)
 	if err != nil {
 		return nil, fmt.Errorf("wait for callback: %s", err)
 	}
-	if resp.State != state {
+	return ar.exchange(context.Background(), &p.Config, resp)
+}
+
+// ManualTokenSource implements a TokenSource for machines without a browser.
+//
+// The user opens the authorization URL elsewhere and pastes back the URL they
+// were redirected to.
+type ManualTokenSource struct {
+	Config oauth2.Config
+	// PKCE enables Proof Key for Code Exchange (RFC 7636).
+	PKCE bool
+	In   io.Reader
+	Out  io.Writer
+}
+
+func (m *ManualTokenSource) Token() (*oauth2.Token, error) {
+	ar, err := newAuthRequest(m.PKCE)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(m.Out, "open this URL in a browser to authenticate.\n\n%s\n\n", ar.authCodeURL(&m.Config))
+	fmt.Fprintf(m.Out, "paste the URL you were redirected to: ")
+
+	line, err := bufio.NewReader(m.In).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return nil, fmt.Errorf("read redirect URL: %s", err)
+	}
+	resp, err := parseCallback(line)
+	if err != nil {
+		return nil, err
+	}
+	return ar.exchange(context.Background(), &m.Config, resp)
+}
+
+// authRequest holds the per-request secrets of a single authorization code flow.
+type authRequest struct {
+	state    string
+	verifier string
+}
+
+func newAuthRequest(pkce bool) (*authRequest, error) {
+	state, err := newState()
+	if err != nil {
+		return nil, fmt.Errorf("generate csrf token: %s", err)
+	}
+	ar := &authRequest{state: state}
+	if pkce {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate code verifier: %s", err)
+		}
+		ar.verifier = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	return ar, nil
+}
+
+func (ar *authRequest) authCodeURL(config *oauth2.Config) string {
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if ar.verifier != "" {
+		challenge := sha256.Sum256([]byte(ar.verifier))
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+	return config.AuthCodeURL(ar.state, opts...)
+}
+
+// exchange validates the callback and trades its code for a token.
+//
+// A callback without a state is only accepted when PKCE is in use, since the
+// code verifier already binds the code to this request.
+func (ar *authRequest) exchange(ctx context.Context, config *oauth2.Config, resp callbackResponse) (*oauth2.Token, error) {
+	if resp.Error != "" {
+		return nil, fmt.Errorf("authorization denied: %s", resp.Error)
+	}
+	if resp.Code == "" {
+		return nil, fmt.Errorf("callback did not include a code")
+	}
+	if resp.State == "" && ar.verifier == "" {
+		return nil, fmt.Errorf("callback did not include a state; provide the full redirect URL")
+	}
+	if resp.State != "" && resp.State != ar.state {
 		return nil, fmt.Errorf("callback state mismatch")
 	}
-	return p.Config.Exchange(ctx, resp.Code, oauth2.AccessTypeOffline)
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if ar.verifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", ar.verifier))
+	}
+	return config.Exchange(ctx, resp.Code, opts...)
 }
 
 func newState() (string, error) {
@@ -96,37 +300,87 @@ func newState() (string, error) {
 	return s, nil
 }
 
+// callbackAddr returns the local address to listen on given a redirect URL.
+func callbackAddr(redirectURL string) (string, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", fmt.Errorf("redirect URL: %s", err)
+	}
+	port := u.Port()
+	if port == "" {
+		return "", fmt.Errorf("redirect URL '%s' has no port to listen on", redirectURL)
+	}
+	return ":" + port, nil
+}
+
 type callbackResponse struct {
 	Code  string
 	State string
+	Error string
 }
 
-func waitForCallback(addr, csrfToken string) (resp callbackResponse, err error) {
-	defer func() {
-		if v := recover(); v != nil {
-			err = fmt.Errorf("server panicked")
-		}
-	}()
-	c := make(chan callbackResponse)
-	var once sync.Once
+// parseCallback extracts the callback parameters from a redirect URL, its query
+// string, or a bare authorization code.
+func parseCallback(input string) (callbackResponse, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return callbackResponse{}, fmt.Errorf("no redirect URL provided")
+	}
+	if !strings.Contains(input, "=") {
+		return callbackResponse{Code: input}, nil
+	}
+	query := input
+	if i := strings.Index(input, "?"); i >= 0 {
+		query = input[i+1:]
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return callbackResponse{}, fmt.Errorf("parse redirect URL: %s", err)
+	}
+	return callbackResponse{
+		Code:  values.Get("code"),
+		State: values.Get("state"),
+		Error: values.Get("error"),
+	}, nil
+}
+
+func waitForCallback(ctx context.Context, addr string) (callbackResponse, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return callbackResponse{}, err
+	}
+	c := make(chan callbackResponse, 1)
 	server := &http.Server{
-		Addr: addr,
 		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			code := req.FormValue("code")
-			state := req.FormValue("state")
-			once.Do(func() {
-				c <- callbackResponse{Code: code, State: state}
-			})
+			resp := callbackResponse{
+				Code:  req.FormValue("code"),
+				State: req.FormValue("state"),
+				Error: req.FormValue("error"),
+			}
+			if resp.Code == "" && resp.Error == "" {
+				// Not the redirect, e.g. a request for /favicon.ico
+				http.NotFound(res, req)
+				return
+			}
+			select {
+			case c <- resp:
+			default:
+			}
 			res.Write([]byte("✅ Go back to your terminal."))
 		}),
 	}
+	errc := make(chan error, 1)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			panic(err)
-		}
+		errc <- server.Serve(ln)
 	}()
-	// TODO: Add a timeout
-	resp = <-c
-	err = server.Shutdown(context.TODO())
-	return
+	defer server.Shutdown(context.Background())
+
+	select {
+	case resp := <-c:
+		return resp, nil
+	case err := <-errc:
+		return callbackResponse{}, err
+	case <-ctx.Done():
+		return callbackResponse{}, fmt.Errorf("no callback received: %s", ctx.Err())
+	}
 }
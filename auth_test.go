@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCachingTokenSourceRefresh(t *testing.T) {
+	r := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Equal("refresh_token", req.FormValue("grant_type"))
+		r.Equal("old-refresh", req.FormValue("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new-access","refresh_token":"new-refresh","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	store := &FileTokenStore{Path: filepath.Join(dir, "token")}
+	r.NoError(store.Save(&oauth2.Token{
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	}))
+
+	cts := &CachingTokenSource{
+		Store: store,
+		Config: &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: server.URL},
+		},
+		NonInteractive: true,
+	}
+	token, err := cts.Token()
+	r.NoError(err)
+	r.Equal("new-access", token.AccessToken)
+
+	stored, err := store.Load()
+	r.NoError(err)
+	r.Equal("new-access", stored.AccessToken)
+	r.Equal("new-refresh", stored.RefreshToken)
+
+	fi, err := os.Stat(store.Path)
+	r.NoError(err)
+	r.Equal(os.FileMode(0600), fi.Mode().Perm())
+}
+
+func TestCachingTokenSourceNonInteractive(t *testing.T) {
+	r := require.New(t)
+
+	cts := &CachingTokenSource{
+		Store:          &FileTokenStore{Path: filepath.Join(t.TempDir(), "token")},
+		Authorize:      oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "unused"}),
+		NonInteractive: true,
+	}
+	_, err := cts.Token()
+	r.True(errors.Is(err, ErrReauthRequired))
+}
+
+func TestParseCallback(t *testing.T) {
+	r := require.New(t)
+
+	resp, err := parseCallback("http://localhost:4000/auth_redirect?code=abc&state=xyz\n")
+	r.NoError(err)
+	r.Equal(callbackResponse{Code: "abc", State: "xyz"}, resp)
+
+	resp, err = parseCallback("code=abc&state=xyz")
+	r.NoError(err)
+	r.Equal(callbackResponse{Code: "abc", State: "xyz"}, resp)
+
+	resp, err = parseCallback("  abc  ")
+	r.NoError(err)
+	r.Equal(callbackResponse{Code: "abc"}, resp)
+
+	_, err = parseCallback("\n")
+	r.Error(err)
+}
+
+func TestAuthRequestValidatesState(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	config := &oauth2.Config{}
+
+	ar, err := newAuthRequest(false)
+	r.NoError(err)
+	_, err = ar.exchange(ctx, config, callbackResponse{Code: "abc", State: "wrong"})
+	r.EqualError(err, "callback state mismatch")
+	_, err = ar.exchange(ctx, config, callbackResponse{Code: "abc"})
+	r.Error(err)
+	_, err = ar.exchange(ctx, config, callbackResponse{Error: "access_denied"})
+	r.EqualError(err, "authorization denied: access_denied")
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"sort"
 	"time"
 
 	"budgetbridge/ynab"
@@ -22,110 +24,339 @@ type BudgetBridge struct {
 	LookBackDays int64
 	ynabClient   ynabClient
 	providers    []NamedProvider
-	categories   []ynab.Category
+	categories   *CategoryIndex
+	rules        Rules
+	suggestions  SuggestionConfig
 	dryRun       bool
+
+	// Since and Until bound the dates of the transactions to import when
+	// backfilling. Until is exclusive, and either may be zero if unbounded.
+	Since, Until time.Time
+	// WindowDays is the number of days fetched and created at a time when
+	// backfilling.
+	WindowDays int
+
+	// The destination and format of the dry run output.
+	output       io.Writer
+	outputFormat string
+
+	// interactive presents each transaction for review before it's created,
+	// reading from input.
+	interactive bool
+	input       io.Reader
+	// state is what's remembered between runs.
+	state *State
+	// journal records what each run created, unless this is a dry run.
+	journal *Journal
 }
 
-func (bb BudgetBridge) ImportAll(ctx context.Context, config Config) error {
-	var transactions []ynab.Transaction
-	for _, provider := range bb.providers {
-		log.Debug().Str("provider", provider.Name).Msg("load transactions")
+// A dateWindow is a range of dates to import, where Until is exclusive. Zero
+// values are unbounded.
+type dateWindow struct {
+	Since, Until time.Time
+}
+
+// windows splits a backfill into windows of at most WindowDays days so that
+// each batch of requests stays within the limits of the APIs.
+func (bb BudgetBridge) windows() []dateWindow {
+	if bb.Since.IsZero() || bb.WindowDays <= 0 {
+		return []dateWindow{{bb.Since, bb.Until}}
+	}
+	until := bb.Until
+	if until.IsZero() {
+		// Include everything dated today.
+		until = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	}
+	var windows []dateWindow
+	for since := bb.Since; since.Before(until); since = since.AddDate(0, 0, bb.WindowDays) {
+		end := since.AddDate(0, 0, bb.WindowDays)
+		if end.After(until) {
+			end = until
+		}
+		windows = append(windows, dateWindow{since, end})
+	}
+	return windows
+}
+
+func (bb BudgetBridge) ImportAll(ctx context.Context) error {
+	categoryName := bb.categories.Name
+
+	var review *reviewer
+	if bb.interactive {
+		review = &reviewer{
+			prompter:     newPrompter(bb.input, bb.output),
+			categories:   bb.categories,
+			categoryName: categoryName,
+			state:        bb.state,
+		}
+	}
+
+	run := JournalRun{
+		ID:       newRunID(time.Now()),
+		Time:     time.Now(),
+		BudgetID: bb.BudgetID,
+	}
+	for _, p := range bb.providers {
+		run.Providers = append(run.Providers, p.Name)
+	}
+	if bb.journal != nil && !bb.dryRun {
+		// Whatever was created is recorded even if a later window fails.
+		defer func() {
+			if err := bb.journal.Record(run); err != nil {
+				log.Err(err).Msg("could not record the run in the journal")
+			} else if len(run.Created) > 0 {
+				log.Info().Str("run", run.ID).Msg("run recorded, undo it with `budgetbridge undo`")
+			}
+		}()
+	}
+
+	suggest, err := bb.loadSuggester(ctx)
+	if err != nil {
+		log.Err(err).Msg("could not learn category suggestions")
+	}
 
-		// Get the most recent YNAB transactions from this account
-		res, err := bb.ynabClient.Transactions(ctx, ynab.TransactionsRequest{
-			BudgetID:  bb.BudgetID,
-			AccountID: provider.AccountID,
-			SinceDate: time.Now().AddDate(0, 0, -int(config.LookBackDays)),
-		})
+	windows := bb.windows()
+	filtered := make(filterCounts)
+	var created, duplicates int
+	var plan []plannedTransaction
+	for i, window := range windows {
+		if len(windows) > 1 {
+			log.Info().
+				Int("window", i+1).
+				Int("windows", len(windows)).
+				Str("since", window.Since.Format("2006-01-02")).
+				Str("until", window.Until.Format("2006-01-02")).
+				Msg("backfilling")
+		}
+		fetched, dropped := bb.rules.apply(suggest.apply(bb.state.apply(bb.fetchAll(ctx, window, filtered))))
+		if len(dropped) > 0 {
+			log.Info().Int("dropped", len(dropped)).Msg("transactions dropped by rules")
+		}
+		if review != nil && len(fetched) > 0 {
+			var err error
+			if fetched, err = review.review(fetched); err != nil {
+				return err
+			}
+		}
+		transactions := make([]ynab.Transaction, len(fetched))
+		for i, t := range fetched {
+			transactions[i] = t.ynabTransaction()
+		}
+		if bb.dryRun {
+			existing, err := bb.existingTransactions(ctx, transactions)
+			if err != nil {
+				return err
+			}
+			planned := planTransactions(transactions, existing, categoryName)
+			for i := range planned {
+				planned[i].Rules = fetched[i].Trace
+				planned[i].Suggestion = fetched[i].Suggestion
+			}
+			for _, t := range dropped {
+				planned = append(planned, plannedTransaction{
+					Action:      planDrop,
+					Transaction: t.ynabTransaction(),
+					Rules:       t.Trace,
+					Suggestion:  t.Suggestion,
+				})
+			}
+			plan = append(plan, planned...)
+			continue
+		}
+		res, err := bb.createAll(ctx, transactions)
 		if err != nil {
-			log.Err(err).Str("provider", provider.Name).Msg("fetch most recent txs failed")
+			return err
+		}
+		run.Created = append(run.Created, journalTransactions(res.Transactions, fetched)...)
+		created += len(res.Transactions)
+		duplicates += len(res.DuplicateImportIDs)
+	}
+	if bb.dryRun {
+		log.Info().Msg("DRY RUN: No transactions will be created.")
+		if err := writePlan(bb.output, bb.outputFormat, plan, filtered.total(), categoryName); err != nil {
+			return err
+		}
+	}
+	bb.reportFiltered(filtered)
+	bb.reportUnmapped()
+	if bb.dryRun {
+		return nil
+	}
+	if len(windows) > 1 {
+		log.Info().
+			Int("created", created).
+			Int("duplicates", duplicates).
+			Int("filtered", filtered.total()).
+			Msg("backfill complete")
+	}
+	return nil
+}
+
+// reportUnmapped lists the categories which providers couldn't map to a YNAB
+// category during the run, and warns about the mappings which refer to a
+// category that can't be used.
+func (bb BudgetBridge) reportUnmapped() {
+	for _, provider := range bb.providers {
+		reporter, ok := provider.TransactionProvider.(unmappedReporter)
+		if !ok {
+			continue
+		}
+		broken := reporter.BrokenMappings()
+		mappings := make([]string, 0, len(broken))
+		for mapping := range broken {
+			mappings = append(mappings, mapping)
+		}
+		sort.Strings(mappings)
+		for _, mapping := range mappings {
+			log.Warn().
+				Str("provider", provider.Name).
+				Str("mapping", mapping).
+				Int("records", broken[mapping]).
+				Msg("category mapping refers to an unknown, hidden or deleted YNAB category")
+		}
+
+		unmapped := reporter.UnmappedCategories()
+		if len(unmapped) == 0 {
+			continue
+		}
+		categories := make([]string, 0, len(unmapped))
+		for category := range unmapped {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		if bb.output == nil || bb.outputFormat != formatTable {
+			log.Warn().
+				Str("provider", provider.Name).
+				Strs("categories", categories).
+				Msg("some categories have no mapping")
 			continue
 		}
+		fmt.Fprintf(bb.output, "\n%s categories with no mapping:\n", provider.Name)
+		for _, category := range categories {
+			fmt.Fprintf(bb.output, "  %s: %d\n", category, unmapped[category])
+		}
+	}
+}
 
-		mostRecentDate := time.Time(res.Transactions[len(res.Transactions)-1].Date)
-		log.Info().Str("provider", provider.Name).Time("since", mostRecentDate).Msg("fetching transactions")
+// lastUpdateHint returns the date of the most recent transaction within the
+// look back period of a provider's account.
+func (bb BudgetBridge) lastUpdateHint(ctx context.Context, provider NamedProvider) (time.Time, error) {
+	sinceDate := time.Now().AddDate(0, 0, -int(bb.LookBackDays))
+	res, err := bb.ynabClient.Transactions(ctx, ynab.TransactionsRequest{
+		BudgetID:  bb.BudgetID,
+		AccountID: provider.AccountID,
+		SinceDate: sinceDate,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(res.Transactions) == 0 {
+		return sinceDate, nil
+	}
+	return time.Time(res.Transactions[len(res.Transactions)-1].Date), nil
+}
+
+// fetchAll loads the transactions of every provider within the window,
+// leaving out and counting those filtered out by the provider.
+func (bb BudgetBridge) fetchAll(ctx context.Context, window dateWindow, filtered filterCounts) []SourceTransaction {
+	var transactions []SourceTransaction
+	for _, provider := range bb.providers {
+		log.Debug().Str("provider", provider.Name).Msg("load transactions")
+
+		info := YnabInfo{
+			Since:      window.Since,
+			Until:      window.Until,
+			Categories: bb.categories,
+			Filter: func(r SourceRecord) string {
+				return filtered.check(provider, r)
+			},
+		}
+		if window.Since.IsZero() {
+			// Get the most recent YNAB transactions from this account
+			hint, err := bb.lastUpdateHint(ctx, provider)
+			if err != nil {
+				log.Err(err).Str("provider", provider.Name).Msg("fetch most recent txs failed")
+				continue
+			}
+			info.LastUpdateHint = hint
+			log.Info().Str("provider", provider.Name).Time("since", hint).Msg("fetching transactions")
+		}
 
-		fetched, err := provider.Transactions(ctx, YnabInfo{
-			LastUpdateHint: mostRecentDate,
-			Categories:     bb.categories,
-		})
+		fetched, err := provider.Transactions(ctx, info)
 		if err != nil {
 			log.Err(err).Str("provider", provider.Name).Msg("transactions failed")
 			continue
 		}
 		for i := 0; i < len(fetched); i++ {
 			fetched[i].AccountId = provider.AccountID
+			fetched[i].Source.Provider = provider.Name
+			provider.Defaults.apply(&fetched[i].Transaction)
 		}
-		transactions = append(transactions, fetched...)
+		transactions = append(transactions, filtered.filter(provider, fetched)...)
 	}
+	return transactions
+}
 
-	// FIXME: ideally this map could be precomputed.
-	categoriesByID := make(map[string]ynab.Category)
-	for _, c := range bb.categories {
-		categoriesByID[c.Id] = c
+// journalTransactions pairs the created transactions with the records they
+// were created from.
+func journalTransactions(created []ynab.Transaction, sources []SourceTransaction) []JournalTransaction {
+	type key struct{ account, importID string }
+	byImportID := make(map[key]SourceTransaction)
+	for _, t := range sources {
+		if t.ImportId != nil {
+			byImportID[key{t.AccountId, *t.ImportId}] = t
+		}
 	}
+	var journaled []JournalTransaction
+	for _, t := range created {
+		jt := JournalTransaction{
+			TransactionID: t.Id,
+			AccountID:     t.AccountId,
+		}
+		if t.ImportId != nil {
+			jt.ImportID = *t.ImportId
+			source := byImportID[key{t.AccountId, *t.ImportId}]
+			jt.Source, jt.Rules = source.Source, source.Trace
+		}
+		journaled = append(journaled, jt)
+	}
+	return journaled
+}
 
-	if bb.dryRun {
-		log.Info().Msg("DRY RUN: No transactions will be created.")
-		for _, t := range transactions {
-			var importID string
-			var categoryID string
-			var categoryName string
+// createAll creates the transactions.
+func (bb BudgetBridge) createAll(ctx context.Context, transactions []ynab.Transaction) (ynab.TransactionsResponse, error) {
+	if len(transactions) == 0 {
+		return ynab.TransactionsResponse{}, nil
+	}
+	request := ynab.CreateTransactionsRequest{
+		Transactions: transactions,
+	}
+	res, err := bb.ynabClient.CreateTransactions(ctx, bb.BudgetID, request)
+	if err != nil {
+		return res, fmt.Errorf("could not create transactions: %s", err)
+	}
+	if len(res.Transactions) > 0 {
+		for _, t := range res.Transactions {
+			importID := "<unset>"
 			if t.ImportId != nil {
 				importID = *t.ImportId
 			}
-			if t.CategoryId != nil {
-				categoryID = *t.CategoryId
-				if c, ok := categoriesByID[categoryID]; ok {
-					categoryName = c.Name
-				}
-			}
 			log.Info().
 				Dict("transaction", zerolog.Dict().
 					Time("date", t.Date.Time()).
 					Str("memo", t.Memo).
 					Int("amount", t.Amount).
 					Str("payeeName", t.PayeeName).
-					Str("importID", importID).
-					Str("category.id", categoryID).
-					Str("category.name", categoryName),
+					Str("importID", importID),
 				).
-				Msg("DRY RUN: would create")
+				Msg("created transaction")
 		}
-		return nil
+		log.Info().Int("count", len(res.Transactions)).Msg("transactions successfully created")
+	} else {
+		log.Info().Msg("no new transactions were created")
 	}
-
-	if len(transactions) > 0 {
-		request := ynab.CreateTransactionsRequest{
-			Transactions: transactions,
-		}
-		res, err := bb.ynabClient.CreateTransactions(ctx, bb.BudgetID, request)
-		if err != nil {
-			return fmt.Errorf("could not create transactions: %s", err)
-		}
-		if len(res.Transactions) > 0 {
-			for _, t := range res.Transactions {
-				importID := "<unset>"
-				if t.ImportId != nil {
-					importID = *t.ImportId
-				}
-				log.Info().
-					Dict("transaction", zerolog.Dict().
-						Time("date", t.Date.Time()).
-						Str("memo", t.Memo).
-						Int("amount", t.Amount).
-						Str("payeeName", t.PayeeName).
-						Str("importID", importID),
-					).
-					Msg("created transaction")
-			}
-			log.Info().Int("count", len(res.Transactions)).Msg("transactions successfully created")
-		} else {
-			log.Info().Msg("no new transactions were created")
-		}
-		if len(res.DuplicateImportIDs) > 0 {
-			log.Info().Int("count", len(res.DuplicateImportIDs)).Msg("duplicate transaction IDs were ignored.")
-		}
+	if len(res.DuplicateImportIDs) > 0 {
+		log.Info().Int("count", len(res.DuplicateImportIDs)).Msg("duplicate transaction IDs were ignored.")
 	}
-	return nil
+	return res, nil
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeYNAB struct {
+	transactions ynab.TransactionsResponse
+	created      [][]ynab.Transaction
+}
+
+func (f *fakeYNAB) Budgets(context.Context) (ynab.BudgetsResponse, error) {
+	return ynab.BudgetsResponse{}, nil
+}
+
+func (f *fakeYNAB) Transactions(context.Context, ynab.TransactionsRequest) (ynab.TransactionsResponse, error) {
+	return f.transactions, nil
+}
+
+func (f *fakeYNAB) CreateTransactions(_ context.Context, _ string, req ynab.CreateTransactionsRequest) (ynab.TransactionsResponse, error) {
+	f.created = append(f.created, req.Transactions)
+	return ynab.TransactionsResponse{Transactions: req.Transactions}, nil
+}
+
+// fakeProvider returns a single transaction for every call, recording the
+// info it was called with.
+type fakeProvider struct {
+	calls []YnabInfo
+}
+
+func (f *fakeProvider) Transactions(_ context.Context, info YnabInfo) ([]SourceTransaction, error) {
+	f.calls = append(f.calls, info)
+	return []SourceTransaction{{
+		Transaction: ynab.Transaction{Memo: "expense"},
+		Source:      SourceRecord{ID: "1", Category: "Groceries"},
+	}}, nil
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBudgetBridgeWindows(t *testing.T) {
+	r := require.New(t)
+
+	bb := BudgetBridge{
+		Since:      date(2020, 1, 1),
+		Until:      date(2020, 3, 1),
+		WindowDays: 30,
+	}
+	r.Equal([]dateWindow{
+		{date(2020, 1, 1), date(2020, 1, 31)},
+		{date(2020, 1, 31), date(2020, 3, 1)},
+	}, bb.windows())
+
+	bb.WindowDays = 0
+	r.Equal([]dateWindow{{date(2020, 1, 1), date(2020, 3, 1)}}, bb.windows())
+
+	r.Equal([]dateWindow{{}}, BudgetBridge{WindowDays: 30}.windows())
+}
+
+func TestImportAllBackfill(t *testing.T) {
+	r := require.New(t)
+
+	client := &fakeYNAB{}
+	provider := &fakeProvider{}
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: client,
+		providers: []NamedProvider{
+			{Name: "fake", AccountID: "account", TransactionProvider: provider},
+		},
+		Since:      date(2020, 1, 1),
+		Until:      date(2020, 1, 25),
+		WindowDays: 10,
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+
+	r.Len(provider.calls, 3)
+	r.Equal(date(2020, 1, 21), provider.calls[2].Since)
+	r.Equal(date(2020, 1, 25), provider.calls[2].Until)
+	r.Len(client.created, 3, "each window is created separately")
+	r.Equal("account", client.created[0][0].AccountId)
+}
+
+func TestImportAllReviewAcrossWindows(t *testing.T) {
+	for action, created := range map[string]int{"l": 3, "q": 0} {
+		client := &fakeYNAB{}
+		var out bytes.Buffer
+		bb := BudgetBridge{
+			BudgetID:   "budget",
+			ynabClient: client,
+			providers: []NamedProvider{
+				{Name: "fake", AccountID: "account", TransactionProvider: &fakeProvider{}},
+			},
+			Since:       date(2020, 1, 1),
+			Until:       date(2020, 1, 25),
+			WindowDays:  10,
+			interactive: true,
+			input:       strings.NewReader(action + "\n"),
+			output:      &out,
+		}
+		require.NoError(t, bb.ImportAll(context.Background()), action)
+		require.Equal(t, 1, strings.Count(out.String(), "[1/1]"), "%s applies to later windows without asking", action)
+		var n int
+		for _, transactions := range client.created {
+			n += len(transactions)
+		}
+		require.Equal(t, created, n, action)
+	}
+}
+
+func TestImportAllEmptyAccount(t *testing.T) {
+	r := require.New(t)
+
+	client := &fakeYNAB{}
+	provider := &fakeProvider{}
+	bb := BudgetBridge{
+		BudgetID:     "budget",
+		LookBackDays: 30,
+		ynabClient:   client,
+		providers: []NamedProvider{
+			{Name: "fake", AccountID: "account", TransactionProvider: provider},
+		},
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+
+	r.Len(provider.calls, 1)
+	hint := provider.calls[0].LastUpdateHint
+	r.WithinDuration(time.Now().AddDate(0, 0, -30), hint, time.Minute)
+	r.Len(client.created, 1)
+}
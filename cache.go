@@ -6,24 +6,85 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"budgetbridge/ynab"
 
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	ynabCacheName    = "ynab_cache.json"
+	ynabCacheLogName = "ynab_cache.log"
+)
+
 type CachingClient struct {
 	client *ynab.Client
 	cache  Cache
+	config CacheConfig
 }
 
+// A Cache stores fetched values by key. Implementations must be safe for
+// concurrent use.
 type Cache interface {
 	Open() error
 	Close() error
 	Get(key string, res interface{}) error
-	Set(key string, res interface{}) error
+	// Peek returns the stored value of an entry, even if it has expired.
+	Peek(key string) (json.RawMessage, error)
+	// Set stores res under key. If ttl is non-zero the entry expires after that long.
+	Set(key string, res interface{}, ttl time.Duration) error
+	Delete(key string) error
+	// List returns information about every entry with the given key prefix.
+	List(prefix string) ([]CacheEntryInfo, error)
+}
+
+// CacheEntryInfo describes a single cache entry.
+type CacheEntryInfo struct {
+	Key       string
+	StoredAt  time.Time
+	ExpiresAt *time.Time
+	Size      int
+}
+
+func (info CacheEntryInfo) Expired(now time.Time) bool {
+	return info.ExpiresAt != nil && !now.Before(*info.ExpiresAt)
+}
+
+// newYNABCache creates the cache backend selected by the config.
+func newYNABCache(config CacheConfig) (Cache, error) {
+	switch config.Backend {
+	case "", "file":
+		return &FileCache{
+			path:          path.Join(config.Dir, ynabCacheName),
+			createMissing: config.CreateMissingDir,
+		}, nil
+	case "log":
+		return &LogCache{
+			path:          path.Join(config.Dir, ynabCacheLogName),
+			createMissing: config.CreateMissingDir,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown cache backend '%s'", config.Backend)
+}
+
+func budgetsCacheKey() string {
+	return "budgets"
+}
+
+func accountsCacheKey(budgetID string) string {
+	return fmt.Sprintf("accounts/%s", budgetID)
+}
+
+func categoriesCacheKey(budgetID string) string {
+	return fmt.Sprintf("categories/%s", budgetID)
 }
 
 func (c *CachingClient) CreateTransactions(ctx context.Context, budgetID string, req ynab.CreateTransactionsRequest) (ynab.TransactionsResponse, error) {
@@ -35,116 +96,263 @@ func (c *CachingClient) Transactions(ctx context.Context, req ynab.TransactionsR
 }
 
 func (c *CachingClient) Budgets(ctx context.Context) (ynab.BudgetsResponse, error) {
-	cacheKey := "budgets"
-
 	var res ynab.BudgetsResponse
-	err := c.cache.Get(cacheKey, &res)
-	if errors.Is(err, errNotFound) {
-		res, err := c.client.Budgets(ctx)
-		if err != nil {
-			return res, fmt.Errorf("failed to fetch: %s", err)
-		}
-		if err := c.cache.Set(cacheKey, &res); err != nil {
-			return res, fmt.Errorf("failed to write to cache: %s", err)
-		}
-		return res, err
-	}
-	if err != nil {
-		// Some non-recoverable error.
-		return res, err
-	}
-	return res, nil
+	err := c.cached(budgetsCacheKey(), c.config.Budgets, &res, func() (err error) {
+		res, err = c.client.Budgets(ctx)
+		return
+	})
+	return res, err
 }
 
-func (c *CachingClient) Categories(ctx context.Context, req ynab.CategoriesRequest) (ynab.CategoriesResponse, error) {
-	cacheKey := fmt.Sprintf("categories/%s", req.BudgetID)
+func (c *CachingClient) Accounts(ctx context.Context, budgetID string) (ynab.AccountsResponse, error) {
+	var res ynab.AccountsResponse
+	err := c.cached(accountsCacheKey(budgetID), c.config.Accounts, &res, func() (err error) {
+		res, err = c.client.Accounts(ctx, budgetID)
+		return
+	})
+	return res, err
+}
 
+func (c *CachingClient) Categories(ctx context.Context, req ynab.CategoriesRequest) (ynab.CategoriesResponse, error) {
 	var res ynab.CategoriesResponse
-	err := c.cache.Get(cacheKey, &res)
+	err := c.cached(categoriesCacheKey(req.BudgetID), c.config.Categories, &res, func() (err error) {
+		res, err = c.client.Categories(ctx, req)
+		return
+	})
+	return res, err
+}
+
+// InvalidateAccounts removes the cached accounts of a budget so that they are
+// fetched again on the next call to Accounts.
+func (c *CachingClient) InvalidateAccounts(budgetID string) error {
+	return c.cache.Delete(accountsCacheKey(budgetID))
+}
+
+// InvalidateCategories removes the cached categories of a budget so that they
+// are fetched again on the next call to Categories.
+func (c *CachingClient) InvalidateCategories(budgetID string) error {
+	return c.cache.Delete(categoriesCacheKey(budgetID))
+}
+
+// cached loads res from the cache, or otherwise populates it using fetch and
+// writes it to the cache.
+func (c *CachingClient) cached(key string, entity CacheEntityConfig, res interface{}, fetch func() error) error {
+	if entity.Disabled {
+		return fetch()
+	}
+	err := c.cache.Get(key, res)
 	if errors.Is(err, errNotFound) {
-		res, err := c.client.Categories(ctx, req)
-		if err != nil {
-			return res, fmt.Errorf("failed to fetch: %s", err)
+		if err := fetch(); err != nil {
+			return fmt.Errorf("failed to fetch: %s", err)
 		}
-		if err := c.cache.Set(cacheKey, &res); err != nil {
-			return res, fmt.Errorf("failed to write to cache: %s", err)
+		if err := c.cache.Set(key, res, c.config.ttl(entity)); err != nil {
+			return fmt.Errorf("failed to write to cache: %s", err)
 		}
-		return res, err
-	}
-	if err != nil {
-		// Some non-recoverable error.
-		return res, err
+		return nil
 	}
-	return res, nil
+	// Either a hit or some non-recoverable error.
+	return err
 }
 
 var errNotFound error = errors.New("not found")
 
+// FileCache implements a Cache held in memory and written to a single JSON
+// file when closed.
 type FileCache struct {
 	path          string
 	createMissing bool
-	cache         map[string]json.RawMessage
+
+	mu    sync.RWMutex
+	cache map[string]cacheEntry
+	// dirty is set when the in-memory cache differs from the file.
+	dirty bool
+}
+
+type cacheEntry struct {
+	Value     json.RawMessage `json:"value"`
+	StoredAt  time.Time       `json:"stored_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+func newCacheEntry(res interface{}, ttl time.Duration) (cacheEntry, error) {
+	value, err := json.Marshal(res)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	entry := cacheEntry{
+		Value:    value,
+		StoredAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := entry.StoredAt.Add(ttl)
+		entry.ExpiresAt = &expiresAt
+	}
+	return entry, nil
+}
+
+func (e cacheEntry) info(key string) CacheEntryInfo {
+	return CacheEntryInfo{
+		Key:       key,
+		StoredAt:  e.StoredAt,
+		ExpiresAt: e.ExpiresAt,
+		Size:      len(e.Value),
+	}
+}
+
+// lookupEntry decodes the entry for key into res, returning errNotFound if it
+// is missing or expired.
+func lookupEntry(entries map[string]cacheEntry, key string, res interface{}) error {
+	entry, ok := entries[key]
+	// Entries written before expiry was supported have no value.
+	if !ok || entry.Value == nil {
+		log.Debug().Str("key", key).Msg("cache miss")
+		return errNotFound
+	}
+	if entry.info(key).Expired(time.Now()) {
+		log.Debug().Str("key", key).Time("expiredAt", *entry.ExpiresAt).Msg("cache entry expired")
+		return errNotFound
+	}
+	if err := json.Unmarshal(entry.Value, res); err != nil {
+		return err
+	}
+	log.Debug().Str("key", key).Msg("cache hit")
+	return nil
+}
+
+func peekEntry(entries map[string]cacheEntry, key string) (json.RawMessage, error) {
+	entry, ok := entries[key]
+	if !ok || entry.Value == nil {
+		return nil, errNotFound
+	}
+	return entry.Value, nil
+}
+
+func listEntries(entries map[string]cacheEntry, prefix string) []CacheEntryInfo {
+	var infos []CacheEntryInfo
+	for key, entry := range entries {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, entry.info(key))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
 }
 
 func (c *FileCache) Open() error {
 	cache, err := c.loadFromDisk()
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// The cache only holds data which can be fetched again, so rather
+		// than failing, set aside the corrupt file and start over.
+		corruptPath := c.path + ".corrupt"
+		log.Warn().
+			Err(err).
+			Str("path", c.path).
+			Str("movedTo", corruptPath).
+			Msg("cache file is corrupt, rebuilding")
+		if err := os.Rename(c.path, corruptPath); err != nil {
+			return err
+		}
+		c.cache = make(map[string]cacheEntry)
+		c.dirty = true
+		return nil
+	}
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	if err != nil && c.createMissing {
 		log.Debug().Msg("init cache directory")
 		dir := filepath.Dir(c.path)
-		c.cache = make(map[string]json.RawMessage)
+		c.cache = make(map[string]cacheEntry)
 		return os.MkdirAll(dir, os.ModePerm)
 	}
+	if cache == nil {
+		cache = make(map[string]cacheEntry)
+	}
 	c.cache = cache
 	return nil
 }
 
 func (c *FileCache) Get(key string, res interface{}) error {
-	value, ok := c.cache[key]
-	if !ok {
-		log.Debug().Str("key", key).Msg("cache miss")
-		return errNotFound
-	}
-	if err := json.Unmarshal(value, res); err != nil {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return lookupEntry(c.cache, key, res)
+}
+
+func (c *FileCache) Peek(key string) (json.RawMessage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return peekEntry(c.cache, key)
+}
+
+func (c *FileCache) Set(key string, res interface{}, ttl time.Duration) error {
+	entry, err := newCacheEntry(res, ttl)
+	if err != nil {
 		return err
 	}
-	log.Debug().Str("key", key).Msg("cache hit")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache[key] = entry
+	c.dirty = true
 	return nil
 }
 
-func (c *FileCache) Set(key string, res interface{}) error {
-	var value json.RawMessage
-	value, err := json.Marshal(res)
-	if err != nil {
-		return err
+func (c *FileCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[key]; ok {
+		delete(c.cache, key)
+		c.dirty = true
 	}
-	c.cache[key] = value
 	return nil
 }
 
+func (c *FileCache) List(prefix string) ([]CacheEntryInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return listEntries(c.cache, prefix), nil
+}
+
+// Close writes the cache to disk if it has been modified.
+//
+// The file is replaced atomically, so a crash while writing leaves the
+// previous contents intact.
 func (c *FileCache) Close() error {
-	f, err := os.Create(c.path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	err := writeFileAtomic(c.path, 0644, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c.cache)
+	})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	c.dirty = false
+	return nil
+}
 
-	w := bufio.NewWriter(f)
-	defer w.Flush()
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(c.cache)
+// closeCache closes a cache, logging rather than returning any error since it
+// is used while unwinding.
+func closeCache(c Cache) {
+	if err := c.Close(); err != nil {
+		log.Err(err).Msg("failed to write cache")
+	}
 }
 
-func (c *FileCache) loadFromDisk() (map[string]json.RawMessage, error) {
+func (c *FileCache) loadFromDisk() (map[string]cacheEntry, error) {
 	f, err := os.Open(c.path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	var fullCache map[string]json.RawMessage
+	var fullCache map[string]cacheEntry
 	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&fullCache); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LogCache implements a Cache backed by an append-only log.
+//
+// Every change is appended to the log as it is made, so nothing is lost if the
+// process exits without closing the cache. Open replays the log to rebuild the
+// index, discarding a partially written final record. The log is compacted
+// once most of its records have been superseded.
+//
+// LogCache is safe for concurrent use.
+type LogCache struct {
+	path          string
+	createMissing bool
+
+	mu      sync.RWMutex
+	f       *os.File
+	entries map[string]cacheEntry
+	// records is the number of records in the log, live or not.
+	records int
+}
+
+type logRecord struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Entry *cacheEntry `json:"entry,omitempty"`
+}
+
+const (
+	logOpSet    = "set"
+	logOpDelete = "delete"
+
+	// The log is not compacted until it has at least this many records.
+	logCompactMinRecords = 64
+)
+
+func (c *LogCache) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.createMissing {
+		if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	valid, err := c.replay(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() > valid {
+		log.Warn().
+			Str("path", c.path).
+			Int64("bytes", fi.Size()-valid).
+			Msg("discarding incomplete cache log records")
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	c.f = f
+	if c.needsCompaction() {
+		return c.compact()
+	}
+	return nil
+}
+
+// replay rebuilds the index from the log, returning the length of its valid prefix.
+func (c *LogCache) replay(f *os.File) (int64, error) {
+	c.entries = make(map[string]cacheEntry)
+	c.records = 0
+
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Either the end of the log, or a record which was never
+			// completely written.
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		var rec logRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Warn().Err(err).Int64("offset", valid).Msg("invalid cache log record")
+			return valid, nil
+		}
+		switch rec.Op {
+		case logOpSet:
+			if rec.Entry != nil {
+				c.entries[rec.Key] = *rec.Entry
+			}
+		case logOpDelete:
+			delete(c.entries, rec.Key)
+		}
+		c.records++
+		valid += int64(len(line))
+	}
+}
+
+func (c *LogCache) Get(key string, res interface{}) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return lookupEntry(c.entries, key, res)
+}
+
+func (c *LogCache) Peek(key string) (json.RawMessage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return peekEntry(c.entries, key)
+}
+
+func (c *LogCache) Set(key string, res interface{}, ttl time.Duration) error {
+	entry, err := newCacheEntry(res, ttl)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.append(logRecord{Op: logOpSet, Key: key, Entry: &entry}); err != nil {
+		return err
+	}
+	c.entries[key] = entry
+	return nil
+}
+
+func (c *LogCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		return nil
+	}
+	if err := c.append(logRecord{Op: logOpDelete, Key: key}); err != nil {
+		return err
+	}
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *LogCache) List(prefix string) ([]CacheEntryInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return listEntries(c.entries, prefix), nil
+}
+
+func (c *LogCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	if c.needsCompaction() {
+		if err := c.compact(); err != nil {
+			return err
+		}
+	}
+	if err := c.f.Sync(); err != nil {
+		return err
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
+
+func (c *LogCache) append(rec logRecord) error {
+	if c.f == nil {
+		return fmt.Errorf("cache is not open")
+	}
+	line, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	if _, err := c.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.records++
+	return nil
+}
+
+func (c *LogCache) needsCompaction() bool {
+	return c.records >= logCompactMinRecords && c.records > 2*len(c.entries)
+}
+
+// compact rewrites the log with a single record for each live entry.
+func (c *LogCache) compact() error {
+	now := time.Now()
+	live := make(map[string]cacheEntry, len(c.entries))
+	for key, entry := range c.entries {
+		if !entry.info(key).Expired(now) {
+			live[key] = entry
+		}
+	}
+	err := writeFileAtomic(c.path, 0644, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for key, entry := range live {
+			entry := entry
+			if err := encoder.Encode(logRecord{Op: logOpSet, Key: key, Entry: &entry}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compact cache log: %s", err)
+	}
+	f, err := os.OpenFile(c.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.f.Close()
+	c.f = f
+	c.entries = live
+	c.records = len(live)
+	log.Debug().Int("entries", len(live)).Msg("compacted cache log")
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogCacheReplay(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "cache.log")
+	cache := &LogCache{path: path}
+	r.NoError(cache.Open())
+	r.NoError(cache.Set("budgets", "first", 0))
+	r.NoError(cache.Set("budgets", "second", 0))
+	r.NoError(cache.Set("categories/1", "value", 0))
+	r.NoError(cache.Delete("categories/1"))
+
+	// Simulate a crash part way through writing a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	r.NoError(err)
+	_, err = f.WriteString(`{"op":"set","key":"categ`)
+	r.NoError(err)
+	r.NoError(f.Close())
+
+	reopened := &LogCache{path: path}
+	r.NoError(reopened.Open())
+	defer reopened.Close()
+
+	var value string
+	r.NoError(reopened.Get("budgets", &value))
+	r.Equal("second", value)
+	r.True(errors.Is(reopened.Get("categories/1", &value), errNotFound))
+	raw, err := reopened.Peek("budgets")
+	r.NoError(err)
+	r.JSONEq(`"second"`, string(raw))
+
+	// New records are appended after the discarded one.
+	r.NoError(reopened.Set("categories/2", "value", 0))
+	entries, err := reopened.List("categories/")
+	r.NoError(err)
+	r.Len(entries, 1)
+}
+
+func TestLogCacheCompaction(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "cache.log")
+	cache := &LogCache{path: path}
+	r.NoError(cache.Open())
+	for i := 0; i < 2*logCompactMinRecords; i++ {
+		r.NoError(cache.Set("budgets", i, 0))
+	}
+	r.NoError(cache.Close())
+
+	reopened := &LogCache{path: path}
+	r.NoError(reopened.Open())
+	defer reopened.Close()
+	r.Equal(1, reopened.records)
+	var value int
+	r.NoError(reopened.Get("budgets", &value))
+	r.Equal(2*logCompactMinRecords-1, value)
+}
+
+func TestLogCacheConcurrentAccess(t *testing.T) {
+	r := require.New(t)
+
+	cache := &LogCache{path: filepath.Join(t.TempDir(), "cache.log")}
+	r.NoError(cache.Open())
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key/%d", i)
+			for j := 0; j < 50; j++ {
+				var value int
+				if err := cache.Set(key, j, 0); err != nil {
+					t.Error(err)
+				}
+				if err := cache.Get(key, &value); err != nil {
+					t.Error(err)
+				}
+				if _, err := cache.List("key/"); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := cache.List("key/")
+	r.NoError(err)
+	r.Len(entries, 8)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCacheExpiry(t *testing.T) {
+	r := require.New(t)
+
+	cache := &FileCache{path: filepath.Join(t.TempDir(), "cache.json")}
+	r.NoError(cache.Open())
+	r.NoError(cache.Set("budgets", "forever", 0))
+	r.NoError(cache.Set("categories/1", "short", time.Nanosecond))
+	r.NoError(cache.Set("categories/2", "long", time.Hour))
+	time.Sleep(time.Millisecond)
+
+	var value string
+	r.NoError(cache.Get("budgets", &value))
+	r.Equal("forever", value)
+	r.True(errors.Is(cache.Get("categories/1", &value), errNotFound))
+	raw, err := cache.Peek("categories/1")
+	r.NoError(err, "expired entries can still be peeked at")
+	r.JSONEq(`"short"`, string(raw))
+	r.NoError(cache.Get("categories/2", &value))
+	r.Equal("long", value)
+
+	entries, err := cache.List("categories/")
+	r.NoError(err)
+	r.Len(entries, 2)
+	r.Equal("categories/1", entries[0].Key)
+	r.True(entries[0].Expired(time.Now()))
+	r.False(entries[1].Expired(time.Now()))
+
+	r.NoError(cache.Delete("budgets"))
+	r.True(errors.Is(cache.Get("budgets", &value), errNotFound))
+	_, err = cache.Peek("budgets")
+	r.True(errors.Is(err, errNotFound))
+}
+
+func TestCacheConfig(t *testing.T) {
+	r := require.New(t)
+
+	var config CacheConfig
+	r.NoError(json.Unmarshal([]byte(`{
+		"ttl": "7d",
+		"budgets": false,
+		"categories": {"ttl": "6h"}
+	}`), &config))
+	r.True(config.Budgets.Disabled)
+	r.False(config.Categories.Disabled)
+	r.Equal(6*time.Hour, config.ttl(config.Categories))
+	r.Equal(7*24*time.Hour, config.ttl(config.Budgets))
+}
+
+func TestMissingCategoryRefs(t *testing.T) {
+	r := require.New(t)
+
+	groups := []ynab.CategoryGroup{
+		{
+			Categories: []ynab.Category{
+				{Id: "1234", Name: "Groceries"},
+				{Id: "4567", Name: "Internet"},
+				{Id: "7890", Name: "Old", Hidden: true},
+			},
+		},
+	}
+	missing := missingCategoryRefs([]categoryRef{
+		{ID: "1234"},
+		{Name: "Internet"},
+		{ID: "9999", Name: "Internet"},
+		{Name: "Dining Out"},
+		{ID: "7890"},
+		{Name: "Old"},
+	}, newCategoryIndex(groups))
+	r.Equal([]string{"9999", "Dining Out"}, missing, "hidden categories are known by ID and by name")
+}
+
+func TestMissingAccounts(t *testing.T) {
+	rules := Rules{{Name: "trip", Set: RuleSet{Account: "Vacation"}}}
+	config := Config{
+		Providers: Providers{Map: map[string]ProviderConfig{"splitwise": {Account: "Splitwise"}}},
+		Rules:     rules,
+	}
+	require.Equal(t, []string{"Splitwise", "Vacation"}, config.accountNames())
+	accounts := []ynab.Account{{Id: "1", Name: "Splitwise"}}
+	require.Equal(t, []string{"Vacation"}, missingAccounts(config.accountNames(), accounts),
+		"accounts named by rules are checked too")
+}
+
+func TestFileCacheRebuildsCorruptFile(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	r.NoError(ioutil.WriteFile(path, []byte(`{"budgets": {"value": [`), 0644))
+
+	cache := &FileCache{path: path}
+	r.NoError(cache.Open())
+	_, err := os.Stat(path + ".corrupt")
+	r.NoError(err)
+
+	r.NoError(cache.Set("budgets", "value", 0))
+	r.NoError(cache.Close())
+
+	reopened := &FileCache{path: path}
+	r.NoError(reopened.Open())
+	var value string
+	r.NoError(reopened.Get("budgets", &value))
+	r.Equal("value", value)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"budgetbridge/ynab"
+)
+
+// A CategoryIndex looks up the categories of a budget. It's built once per run
+// and shared by everything which needs to find a category.
+//
+// Only usable categories are indexed: those which are hidden or deleted, or
+// whose group is, are left out of every lookup except Name.
+type CategoryIndex struct {
+	categories []ynab.Category
+	byID       map[string]ynab.Category
+	// byName holds every category with a bare name, which may be several.
+	byName map[string][]ynab.Category
+	// byQualifiedName holds each category by its name qualified with the
+	// name of its group, as in "Everyday: Groceries".
+	byQualifiedName map[string]ynab.Category
+	qualifiedNames  map[string]string
+	// names holds the name of every category including unusable ones, so
+	// that existing transactions can be described.
+	names map[string]string
+	// unusableNames holds the IDs of the hidden and deleted categories by
+	// both their bare and qualified names.
+	unusableNames map[string]string
+}
+
+// newCategoryIndex indexes the usable categories of the groups.
+func newCategoryIndex(groups []ynab.CategoryGroup) *CategoryIndex {
+	ci := &CategoryIndex{
+		byID:            make(map[string]ynab.Category),
+		byName:          make(map[string][]ynab.Category),
+		byQualifiedName: make(map[string]ynab.Category),
+		qualifiedNames:  make(map[string]string),
+		names:           make(map[string]string),
+		unusableNames:   make(map[string]string),
+	}
+	for _, g := range groups {
+		for _, c := range g.Categories {
+			ci.names[c.Id] = c.Name
+			qualified := g.Name + qualifiedNameSep + c.Name
+			if g.Deleted || c.Deleted || g.Hidden || c.Hidden {
+				ci.unusableNames[c.Name] = c.Id
+				ci.unusableNames[qualified] = c.Id
+				continue
+			}
+			ci.categories = append(ci.categories, c)
+			ci.byID[c.Id] = c
+			ci.byName[c.Name] = append(ci.byName[c.Name], c)
+			ci.byQualifiedName[qualified] = c
+			ci.qualifiedNames[c.Id] = qualified
+		}
+	}
+	return ci
+}
+
+// Categories returns the usable categories in the order of the budget.
+func (ci *CategoryIndex) Categories() []ynab.Category {
+	if ci == nil {
+		return nil
+	}
+	return ci.categories
+}
+
+// ByID returns the usable category with the given ID.
+func (ci *CategoryIndex) ByID(id string) (ynab.Category, bool) {
+	if ci == nil {
+		return ynab.Category{}, false
+	}
+	c, ok := ci.byID[id]
+	return c, ok
+}
+
+// ByName returns the usable category with the given name, which may be
+// qualified by the name of its group as in "Everyday: Groceries". A bare name
+// shared by categories in different groups is ambiguous.
+func (ci *CategoryIndex) ByName(name string) (ynab.Category, error) {
+	if ci == nil {
+		return ynab.Category{}, &lookupError{"category", name, errNoMatch, nil}
+	}
+	// A bare name may itself contain the separator, so both forms are
+	// always looked up.
+	matches := ci.byName[name]
+	if c, ok := ci.byQualifiedName[name]; ok && !containsCategory(matches, c.Id) {
+		matches = append(matches, c)
+	}
+	switch len(matches) {
+	case 0:
+		var names []string
+		for _, c := range ci.categories {
+			if strings.Contains(name, qualifiedNameSep) {
+				names = append(names, ci.qualifiedNames[c.Id])
+			} else {
+				names = append(names, c.Name)
+			}
+		}
+		return ynab.Category{}, &lookupError{"category", name, errNoMatch, closestMatches(name, names)}
+	case 1:
+		return matches[0], nil
+	default:
+		return ynab.Category{}, &lookupError{"category", name, errAmbiguous, nil}
+	}
+}
+
+// Name returns the name of the category with an ID, or the ID itself if there
+// is no such category. It returns an empty string for a nil ID.
+func (ci *CategoryIndex) Name(id *string) string {
+	if id == nil {
+		return ""
+	}
+	if ci != nil {
+		if name, ok := ci.names[*id]; ok {
+			return name
+		}
+	}
+	return *id
+}
+
+// known reports whether the budget has a category with an ID, even one which
+// is hidden or deleted.
+func (ci *CategoryIndex) known(id string) bool {
+	if ci == nil {
+		return false
+	}
+	_, ok := ci.names[id]
+	return ok
+}
+
+// unusable returns the ID of a hidden or deleted category with a name, which
+// may be qualified by the name of its group.
+func (ci *CategoryIndex) unusable(name string) (string, bool) {
+	if ci == nil {
+		return "", false
+	}
+	id, ok := ci.unusableNames[name]
+	return id, ok
+}
+
+// ids returns the IDs of every category, including unusable ones, sorted.
+func (ci *CategoryIndex) ids() []string {
+	if ci == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(ci.names))
+	for id := range ci.names {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// QualifiedName returns the name of a usable category qualified by its group.
+func (ci *CategoryIndex) QualifiedName(id string) string {
+	if ci == nil {
+		return ""
+	}
+	return ci.qualifiedNames[id]
+}
+
+// UniqueName returns the bare name of a usable category, or its qualified
+// name if the bare name is ambiguous.
+func (ci *CategoryIndex) UniqueName(id string) string {
+	c, ok := ci.ByID(id)
+	if !ok {
+		return ""
+	}
+	if len(ci.byName[c.Name]) > 1 {
+		return ci.qualifiedNames[id]
+	}
+	return c.Name
+}
+
+// resolver returns a function which finds the ID of a category by its name.
+func (ci *CategoryIndex) resolver() func(string) (string, error) {
+	return func(name string) (string, error) {
+		c, err := ci.ByName(name)
+		return c.Id, err
+	}
+}
+
+func containsCategory(categories []ynab.Category, id string) bool {
+	for _, c := range categories {
+		if c.Id == id {
+			return true
+		}
+	}
+	return false
+}
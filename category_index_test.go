@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategoryIndex(t *testing.T) {
+	r := require.New(t)
+
+	index := newCategoryIndex([]ynab.CategoryGroup{
+		{Name: "Everyday", Categories: []ynab.Category{
+			{Id: "groceries", Name: "Groceries"},
+			{Id: "misc-1", Name: "Misc"},
+			{Id: "old", Name: "Old", Hidden: true},
+			{Id: "gone", Name: "Gone", Deleted: true},
+		}},
+		{Name: "Bills", Categories: []ynab.Category{
+			{Id: "misc-2", Name: "Misc"},
+		}},
+		{Name: "Archive", Hidden: true, Categories: []ynab.Category{
+			{Id: "archived", Name: "Archived"},
+		}},
+	})
+
+	var ids []string
+	for _, c := range index.Categories() {
+		ids = append(ids, c.Id)
+	}
+	r.Equal([]string{"groceries", "misc-1", "misc-2"}, ids)
+
+	c, ok := index.ByID("groceries")
+	r.True(ok)
+	r.Equal("Groceries", c.Name)
+	for _, id := range []string{"old", "gone", "archived"} {
+		_, ok = index.ByID(id)
+		r.False(ok, id)
+		_, err := index.ByName(index.Name(&id))
+		r.True(errors.Is(err, errNoMatch), id)
+	}
+
+	c, err := index.ByName("Everyday: Misc")
+	r.NoError(err)
+	r.Equal("misc-1", c.Id)
+	_, err = index.ByName("Misc")
+	r.True(errors.Is(err, errAmbiguous))
+
+	old := "old"
+	r.Equal("Old", index.Name(&old), "unusable categories are still named")
+	unknown := "unknown"
+	r.Equal("unknown", index.Name(&unknown))
+	r.Equal("", index.Name(nil))
+
+	r.Equal("Bills: Misc", index.QualifiedName("misc-2"))
+	r.Equal("Groceries", index.UniqueName("groceries"))
+	r.Equal("Bills: Misc", index.UniqueName("misc-2"))
+
+	var nilIndex *CategoryIndex
+	_, ok = nilIndex.ByID("groceries")
+	r.False(ok)
+	r.Equal("groceries", nilIndex.Name(&ids[0]))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"budgetbridge/ynab"
+)
+
+// ynabCatalog is the part of the YNAB API describing a budget's structure.
+type ynabCatalog interface {
+	Budgets(context.Context) (ynab.BudgetsResponse, error)
+	Accounts(context.Context, string) (ynab.AccountsResponse, error)
+	Categories(context.Context, ynab.CategoriesRequest) (ynab.CategoriesResponse, error)
+}
+
+func runCheck(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx = withVault(ctx, config.vault)
+	// The cache is deliberately bypassed so that the config is checked
+	// against the current state of the budget.
+	client, err := newYNABClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	checker := &configChecker{
+		client: client,
+		out:    os.Stdout,
+	}
+	checker.check(ctx, config)
+	if checker.problems > 0 {
+		return fmt.Errorf("found %d problem(s) in %s", checker.problems, *configPath)
+	}
+	fmt.Fprintln(checker.out, "no problems found")
+	return nil
+}
+
+// configChecker verifies that the IDs and names in a config refer to usable
+// entities within YNAB, reporting each one as it goes.
+type configChecker struct {
+	client   ynabCatalog
+	out      io.Writer
+	problems int
+}
+
+func (cc *configChecker) ok(format string, args ...interface{}) {
+	fmt.Fprintf(cc.out, "ok:      %s\n", fmt.Sprintf(format, args...))
+}
+
+func (cc *configChecker) problem(suggestions []string, format string, args ...interface{}) {
+	cc.problems++
+	msg := fmt.Sprintf(format, args...)
+	if len(suggestions) > 0 {
+		msg = fmt.Sprintf("%s, did you mean %s?", msg, strings.Join(suggestions, " or "))
+	}
+	fmt.Fprintf(cc.out, "problem: %s\n", msg)
+}
+
+func (cc *configChecker) check(ctx context.Context, config Config) {
+	budgets, err := cc.client.Budgets(ctx)
+	if err != nil {
+		cc.problem(nil, "could not fetch budgets: %s", err)
+		return
+	}
+	budgetID, err := getBudgetID(ctx, cc.client, config)
+	if err != nil {
+		cc.problem(nil, "%s", err)
+		return
+	}
+	budget, ok := findBudget(budgets.Budgets, budgetID)
+	if !ok {
+		var ids []string
+		for _, b := range budgets.Budgets {
+			ids = append(ids, b.Id)
+		}
+		cc.problem(quoteAll(closestMatches(budgetID, ids)), "budget_id '%s' does not exist", budgetID)
+		return
+	}
+	cc.ok("budget '%s' (%s)", budget.Name, budget.Id)
+
+	cc.checkAccounts(ctx, budgetID, config.Providers, config.Rules)
+	cc.checkCategories(ctx, budgetID, config.categoryRefs())
+}
+
+func (cc *configChecker) checkAccounts(ctx context.Context, budgetID string, providers Providers, rules Rules) {
+	res, err := cc.client.Accounts(ctx, budgetID)
+	if err != nil {
+		cc.problem(nil, "could not fetch accounts: %s", err)
+		return
+	}
+	accounts := make(map[string]ynab.Account, len(res.Accounts))
+	var ids []string
+	for _, a := range res.Accounts {
+		accounts[a.Id] = a
+		ids = append(ids, a.Id)
+	}
+	for _, name := range providers.names() {
+		providerConfig := providers.Map[name]
+		accountID := providerConfig.AccountID
+		if providerConfig.Account != "" {
+			if accountID != "" {
+				cc.problem(nil, "%s: only one of account_id or account may be configured", name)
+				continue
+			}
+			account, err := findAccountByName(res.Accounts, providerConfig.Account)
+			if err != nil {
+				cc.problem(nil, "%s: %s", name, err)
+				continue
+			}
+			accountID = account.Id
+		}
+		account, ok := accounts[accountID]
+		switch {
+		case accountID == "":
+			cc.problem(nil, "%s: no account_id or account is configured", name)
+		case !ok:
+			cc.problem(quoteAll(closestMatches(accountID, ids)), "%s: account_id '%s' does not exist", name, accountID)
+		case account.Deleted:
+			cc.problem(nil, "%s: account '%s' has been deleted", name, account.Name)
+		case account.Closed:
+			cc.problem(nil, "%s: account '%s' is closed", name, account.Name)
+		default:
+			cc.ok("%s: account '%s' (%s)", name, account.Name, account.Id)
+		}
+	}
+	for _, r := range rules {
+		accountID := r.Set.AccountID
+		if r.Set.Account != "" {
+			account, err := findAccountByName(res.Accounts, r.Set.Account)
+			if err != nil {
+				cc.problem(nil, "rules: rule '%s': %s", r.Name, err)
+				continue
+			}
+			accountID = account.Id
+		}
+		if accountID == "" {
+			continue
+		}
+		account, ok := accounts[accountID]
+		switch {
+		case !ok:
+			cc.problem(quoteAll(closestMatches(accountID, ids)), "rules: rule '%s': account_id '%s' does not exist", r.Name, accountID)
+		case account.Deleted || account.Closed:
+			cc.problem(nil, "rules: rule '%s': account '%s' is closed or deleted", r.Name, account.Name)
+		default:
+			cc.ok("rules: rule '%s': account '%s' (%s)", r.Name, account.Name, account.Id)
+		}
+	}
+}
+
+func (cc *configChecker) checkCategories(ctx context.Context, budgetID string, refs []categoryRef) {
+	if len(refs) == 0 {
+		return
+	}
+	res, err := cc.client.Categories(ctx, ynab.CategoriesRequest{BudgetID: budgetID})
+	if err != nil {
+		cc.problem(nil, "could not fetch categories: %s", err)
+		return
+	}
+	categories := newCategoryIndex(res.CategoryGroups)
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Source < refs[j].Source
+	})
+	for _, ref := range refs {
+		if ref.ID == "" {
+			c, err := categories.ByName(ref.Name)
+			switch {
+			case err == nil:
+				cc.ok("%s: YNAB category '%s' (%s)", ref.Source, c.Name, c.Id)
+			case errors.Is(err, errNoMatch):
+				if _, ok := categories.unusable(ref.Name); ok {
+					cc.problem(nil, "%s: YNAB category '%s' is hidden or deleted", ref.Source, ref)
+					continue
+				}
+				fallthrough
+			default:
+				cc.problem(nil, "%s: YNAB %s", ref.Source, err)
+			}
+			continue
+		}
+		c, ok := categories.ByID(ref.ID)
+		switch {
+		case ok:
+			cc.ok("%s: YNAB category '%s' (%s)", ref.Source, c.Name, c.Id)
+		case categories.known(ref.ID):
+			cc.problem(nil, "%s: YNAB category '%s' is hidden or deleted", ref.Source, ref)
+		default:
+			cc.problem(quoteAll(closestMatches(ref.ID, categories.ids())), "%s: YNAB category '%s' does not exist", ref.Source, ref)
+		}
+	}
+}
+
+func findBudget(budgets []ynab.BudgetSummary, id string) (ynab.BudgetSummary, bool) {
+	for _, b := range budgets {
+		if b.Id == id {
+			return b, true
+		}
+	}
+	return ynab.BudgetSummary{}, false
+}
+
+// closestMatches returns up to three candidates which are a likely misspelling
+// of target, closest first.
+func closestMatches(target string, candidates []string) []string {
+	const maxMatches = 3
+	threshold := len(target) / 3
+	if threshold < 2 {
+		threshold = 2
+	}
+	type match struct {
+		value    string
+		distance int
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		d := levenshtein(strings.ToLower(target), strings.ToLower(c))
+		if d <= threshold {
+			matches = append(matches, match{c, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+	var result []string
+	for i := 0; i < len(matches) && i < maxMatches; i++ {
+		result = append(result, matches[i].value)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("'%s'", v)
+	}
+	return quoted
+}
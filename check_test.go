@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCatalog struct {
+	budgets    ynab.BudgetsResponse
+	accounts   ynab.AccountsResponse
+	categories ynab.CategoriesResponse
+}
+
+func (f *fakeCatalog) Budgets(context.Context) (ynab.BudgetsResponse, error) {
+	return f.budgets, nil
+}
+
+func (f *fakeCatalog) Accounts(context.Context, string) (ynab.AccountsResponse, error) {
+	return f.accounts, nil
+}
+
+func (f *fakeCatalog) Categories(context.Context, ynab.CategoriesRequest) (ynab.CategoriesResponse, error) {
+	return f.categories, nil
+}
+
+func TestConfigChecker(t *testing.T) {
+	r := require.New(t)
+
+	catalog := &fakeCatalog{
+		budgets: ynab.BudgetsResponse{
+			Budgets: []ynab.BudgetSummary{{Id: "budget", Name: "Household"}},
+		},
+		accounts: ynab.AccountsResponse{
+			Accounts: []ynab.Account{
+				{Id: "open", Name: "Splitwise"},
+				{Id: "closed", Name: "Old Splitwise", Closed: true},
+			},
+		},
+		categories: ynab.CategoriesResponse{
+			CategoryGroups: []ynab.CategoryGroup{
+				{
+					Name: "Everyday",
+					Categories: []ynab.Category{
+						{Id: "1", Name: "Groceries"},
+						{Id: "2", Name: "Dining Out", Hidden: true},
+					},
+				},
+			},
+		},
+	}
+	mapping := make(CategoryMapping)
+	mapping.Add(CategoryMappingEntry{Name: "Groceries", YnabName: "Grocries"})
+	mapping.Add(CategoryMappingEntry{Name: "Dinner", YnabId: "2"})
+	mapping.Add(CategoryMappingEntry{Name: "Food", YnabId: "1"})
+	mapping.Add(CategoryMappingEntry{Name: "Eating out", YnabName: "Dining Out"})
+	budgetID := "budget"
+	config := Config{
+		BudgetID: &budgetID,
+		Providers: Providers{
+			Map: map[string]ProviderConfig{
+				"splitwise": {
+					AccountID: "open",
+					Options:   &SplitwiseOptions{CategoryMapping: mapping},
+				},
+				"other": {
+					AccountID: "closed",
+					Options:   &SplitwiseOptions{},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	checker := &configChecker{client: catalog, out: &out}
+	checker.check(context.Background(), config)
+	r.Equal(4, checker.problems)
+	r.Equal(`ok:      budget 'Household' (budget)
+problem: other: account 'Old Splitwise' is closed
+ok:      splitwise: account 'Splitwise' (open)
+problem: splitwise: category_mapping 'Dinner': YNAB category '2' is hidden or deleted
+problem: splitwise: category_mapping 'Eating out': YNAB category 'Dining Out' is hidden or deleted
+ok:      splitwise: category_mapping 'Food': YNAB category 'Groceries' (1)
+problem: splitwise: category_mapping 'Groceries': YNAB category 'Grocries' does not exist, did you mean 'Groceries'?
+`, out.String())
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A command is a budgetbridge subcommand.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(ctx context.Context, cmd *command, args []string) error
+}
+
+var commands = []*command{
+	{
+		name:        "accounts",
+		usage:       "accounts [flags]",
+		description: "list the accounts of a YNAB budget",
+		run:         runAccounts,
+	},
+	{
+		name:        "auth",
+		usage:       "auth <ynab|provider> [flags]",
+		description: "authorize access to a service and store the token",
+		run:         runAuth,
+	},
+	{
+		name:        "budgets",
+		usage:       "budgets [flags]",
+		description: "list your YNAB budgets",
+		run:         runBudgets,
+	},
+	{
+		name:        "cache",
+		usage:       "cache <list|show|clear> [key]",
+		description: "list, inspect and clear cached YNAB data",
+		run:         runCache,
+	},
+	{
+		name:        "categories",
+		usage:       "categories [flags]",
+		description: "list the categories of a YNAB budget",
+		run:         runCategories,
+	},
+	{
+		name:        "check",
+		usage:       "check [flags]",
+		description: "check the configured budget, accounts and categories against YNAB",
+		run:         runCheck,
+	},
+	{
+		name:        "explain",
+		usage:       "explain <provider> <id> [flags]",
+		description: "show how a record of a provider, such as a Splitwise expense, becomes a YNAB transaction",
+		run:         runExplain,
+	},
+	{
+		name:        "history",
+		usage:       "history [flags]",
+		description: "list past sync runs",
+		run:         runHistory,
+	},
+	{
+		name:        "init",
+		usage:       "init [flags]",
+		description: "create a config file by choosing a budget, account and category mapping",
+		run:         runInit,
+	},
+	{
+		name:        "splitwise",
+		usage:       "splitwise <categories|friends|groups>",
+		description: "list your Splitwise categories, friends or groups",
+		run:         runSplitwise,
+	},
+	{
+		name:        "sync",
+		usage:       "sync [flags]",
+		description: "import new transactions from every provider into YNAB",
+		run:         runSync,
+	},
+	{
+		name:        "undo",
+		usage:       "undo [run-id] [flags]",
+		description: "delete the transactions created by a sync run, by default the last one",
+		run:         runUndo,
+	},
+	{
+		name:        "vault",
+		usage:       "vault <set|delete|list> [name]",
+		description: "manage the secrets stored in the encrypted vault",
+		run:         runVault,
+	},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flags for a subcommand, including the shared -config flag.
+func newFlagSet(cmd *command) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: budgetbridge %s\n\n%s\n\n", cmd.usage, cmd.description)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "config.json", "the path of your config file (.json, .toml or .yaml)")
+	return fs, configPath
+}
+
+// parseArgs parses the flags of a subcommand, which may be given before,
+// between or after its positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for len(args) > 0 {
+		switch {
+		case args[0] == "--":
+			return append(positional, args[1:]...)
+		case !strings.HasPrefix(args[0], "-") || args[0] == "-":
+			positional = append(positional, args[0])
+			args = args[1:]
+		default:
+			// safety: ExitOnError is used for all subcommands
+			_ = fs.Parse(args)
+			rest := fs.Args()
+			if parsed := args[:len(args)-len(rest)]; parsed[len(parsed)-1] == "--" {
+				// Parsing stopped at "--", so everything after it is positional.
+				return append(positional, rest...)
+			}
+			args = rest
+		}
+	}
+	return positional
+}
+
+func runAuth(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	mode := fs.String("mode", "server", "how to receive the authorization code: 'server' or 'manual'")
+	addr := fs.String("addr", "", "the address to listen on in server mode (default: the port of the redirect URL)")
+	timeout := fs.Duration("timeout", defaultAuthTimeout, "how long to wait for the callback in server mode")
+	pkce := fs.Bool("pkce", false, "use PKCE (RFC 7636) when requesting the code")
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one service to authorize")
+	}
+	service := args[0]
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx = withVault(ctx, config.vault)
+	auth, err := config.authorizer(service)
+	if err != nil {
+		return err
+	}
+	store, err := auth.tokenStore(ctx)
+	if err != nil {
+		return err
+	}
+	oauthConfig := auth.oauthConfig()
+	var source oauth2.TokenSource
+	switch *mode {
+	case "server":
+		source = &LocalServerTokenSource{
+			Config:  *oauthConfig,
+			Addr:    *addr,
+			Timeout: *timeout,
+			PKCE:    *pkce,
+		}
+	case "manual":
+		source = &ManualTokenSource{
+			Config: *oauthConfig,
+			PKCE:   *pkce,
+			In:     os.Stdin,
+			Out:    os.Stdout,
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s'", *mode)
+	}
+	token, err := source.Token()
+	if err != nil {
+		return fmt.Errorf("authorize %s: %s", service, err)
+	}
+	if err := store.Save(token); err != nil {
+		return fmt.Errorf("store token: %s", err)
+	}
+	fmt.Printf("%s authorized successfully.\n", service)
+	return nil
+}
+
+func runVault(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected a vault action")
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if config.vault == nil {
+		return fmt.Errorf("no vault is configured")
+	}
+	action, args := args[0], args[1:]
+	switch {
+	case action == "list" && len(args) == 0:
+		names, err := config.vault.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	case action == "set" && len(args) == 1:
+		value, err := readSecretValue(fmt.Sprintf("value for '%s': ", args[0]))
+		if err != nil {
+			return err
+		}
+		return config.vault.Set(args[0], value)
+	case action == "delete" && len(args) == 1:
+		return config.vault.Delete(args[0])
+	}
+	fs.Usage()
+	return fmt.Errorf("invalid vault action")
+}
+
+// readSecretValue reads a secret by prompting on the terminal, or otherwise
+// from all of stdin so that existing files can be imported, e.g.
+//
+//	budgetbridge vault set splitwise_token < .splitwise.token
+func readSecretValue(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		value, err := readPassword(prompt)
+		return string(value), err
+	}
+	value, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+func runCache(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected a cache action")
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cache, err := newYNABCache(config.Cache)
+	if err != nil {
+		return err
+	}
+	if err := cache.Open(); err != nil {
+		return err
+	}
+	defer closeCache(cache)
+
+	action, args := args[0], args[1:]
+	switch {
+	case action == "list" && len(args) <= 1:
+		var prefix string
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		entries, err := cache.List(prefix)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tSTORED\tEXPIRES\tSIZE")
+		for _, e := range entries {
+			expires := "never"
+			if e.Expired(now) {
+				expires = "expired"
+			} else if e.ExpiresAt != nil {
+				expires = e.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", e.Key, e.StoredAt.Format(time.RFC3339), expires, e.Size)
+		}
+		return w.Flush()
+	case action == "show" && len(args) == 1:
+		// Expired entries are shown too, since list includes them.
+		value, err := cache.Peek(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %s", args[0], err)
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, value, "", "  "); err != nil {
+			return err
+		}
+		fmt.Println(out.String())
+		return nil
+	case action == "clear" && len(args) <= 1:
+		// With no argument every entry is cleared, otherwise the argument
+		// is treated as a key prefix.
+		var prefix string
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		entries, err := cache.List(prefix)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := cache.Delete(e.Key); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("cleared %d entries\n", len(entries))
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("invalid cache action")
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	r := require.New(t)
+
+	fs, configPath := newFlagSet(&command{name: "explain"})
+	format := formatFlag(fs)
+	args := parseArgs(fs, []string{"splitwise", "-config", "c.toml", "123", "-format", "json", "--", "-x"})
+	r.Equal([]string{"splitwise", "123", "-x"}, args)
+	r.Equal("c.toml", *configPath)
+	r.Equal("json", *format)
+}
@@ -1,30 +1,229 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
-	"os"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const defaultBackfillWindowDays = 30
+
 type Config struct {
-	BudgetID     *string     `json:"budget_id"`
-	AccessToken  string      `json:"access_token"`
-	LookBackDays int64       `json:"lookback_days"`
-	Cache        CacheConfig `json:"cache"`
-	Providers    Providers   `json:"providers"`
+	BudgetID *string `json:"budget_id"`
+	// Budget selects the budget by name, as an alternative to BudgetID.
+	Budget       string     `json:"budget"`
+	AccessToken  string     `json:"access_token"`
+	OAuth        *YNABOAuth `json:"oauth"`
+	LookBackDays int64      `json:"lookback_days"`
+	// BackfillWindowDays is how many days are imported at a time when
+	// backfilling with -since.
+	BackfillWindowDays int         `json:"backfill_window_days"`
+	Cache              CacheConfig `json:"cache"`
+	// DataDir is where the state kept between runs is stored, by default
+	// the cache directory.
+	DataDir   string      `json:"data_dir"`
+	Vault     VaultConfig `json:"vault"`
+	Providers Providers   `json:"providers"`
+	// Rules transform the transactions of every provider before they're
+	// created.
+	Rules Rules `json:"rules"`
+	// Suggestions learn the categories of new transactions from those
+	// imported before.
+	Suggestions SuggestionConfig `json:"suggestions"`
+
+	// The vault described by the Vault section, or nil if not configured.
+	vault *Vault
+}
+
+func (config *Config) dataDir() string {
+	if config.DataDir != "" {
+		return config.DataDir
+	}
+	return config.Cache.Dir
 }
 
+// loadConfig reads the config file at path, decoding the options of every
+// known provider.
+func loadConfig(path string) (Config, error) {
+	config := Config{
+		BackfillWindowDays: defaultBackfillWindowDays,
+		Suggestions:        SuggestionConfig{HistoryDays: defaultSuggestionHistoryDays},
+	}
+	err := config.Providers.SetRegistry(map[string]NewProvider{
+		"splitwise": &SplitwiseOptions{},
+	})
+	if err != nil {
+		return config, err
+	}
+	return config, config.load(path)
+}
+
+// load reads the config at path, in a format chosen by its extension.
 func (config *Config) load(path string) error {
-	f, err := os.Open(path)
+	format, err := configFormatFor(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return json.NewDecoder(bufio.NewReader(f)).Decode(config)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tree, positions, err := format(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if err := config.resolveSecrets(tree); err != nil {
+		return locate(path, positions, err)
+	}
+	// Each top-level key is decoded separately so that any error can be
+	// attributed to the key it came from.
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := decodeTree(map[string]interface{}{k: tree[k]}, config); err != nil {
+			return locate(path, positions, keyError(k, err))
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces all secret references in the raw config.
+//
+// The vault section is resolved and decoded first since any other secret
+// may be stored within the vault.
+func (config *Config) resolveSecrets(tree map[string]interface{}) error {
+	if raw, ok := tree["vault"]; ok {
+		if err := (&secretResolver{}).resolve("vault", raw); err != nil {
+			return err
+		}
+		if err := decodeTree(raw, &config.Vault); err != nil {
+			return keyError("vault", err)
+		}
+	}
+	if config.Vault.Path != "" {
+		config.vault = &Vault{
+			Path:       config.Vault.Path,
+			Passphrase: config.Vault.passphrase,
+		}
+	}
+	return (&secretResolver{vault: config.vault}).resolve("", tree)
+}
+
+// decodeTree decodes a generic config value into v as if it were read directly
+// from JSON.
+func decodeTree(tree interface{}, v interface{}) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// authorizer returns the service with the given name which uses oauth2.
+func (config *Config) authorizer(service string) (authorizer, error) {
+	if service == "ynab" {
+		if config.OAuth == nil {
+			return nil, fmt.Errorf("ynab is not configured to use oauth")
+		}
+		return config.OAuth, nil
+	}
+	providerConfig, ok := config.Providers.Map[service]
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not configured", service)
+	}
+	auth, ok := providerConfig.Options.(authorizer)
+	if !ok {
+		return nil, fmt.Errorf("'%s' does not use oauth2", service)
+	}
+	return auth, nil
 }
 
 type CacheConfig struct {
 	Dir              string `json:"dir"`
 	CreateMissingDir bool   `json:"create_missing_dir"`
-	Categories       bool   `json:"categories"`
+	// Backend selects how the cache is stored, either "file" (the default)
+	// or "log".
+	Backend string `json:"backend"`
+	// TTL is how long entries are kept for unless overridden for that kind of
+	// entry. Zero keeps entries forever.
+	TTL        Duration          `json:"ttl"`
+	Budgets    CacheEntityConfig `json:"budgets"`
+	Accounts   CacheEntityConfig `json:"accounts"`
+	Categories CacheEntityConfig `json:"categories"`
+}
+
+// ttl returns how long entries of the given kind are kept for.
+func (cc CacheConfig) ttl(entity CacheEntityConfig) time.Duration {
+	if entity.TTL != 0 {
+		return time.Duration(entity.TTL)
+	}
+	return time.Duration(cc.TTL)
+}
+
+// CacheEntityConfig configures caching for a single kind of entry.
+//
+// It is written either as a boolean to enable or disable caching, or as an
+// object such as {"enabled": true, "ttl": "6h"}.
+type CacheEntityConfig struct {
+	Disabled bool
+	TTL      Duration
+}
+
+func (cec *CacheEntityConfig) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*cec = CacheEntityConfig{Disabled: !enabled}
+		return nil
+	}
+	var raw struct {
+		Enabled *bool    `json:"enabled"`
+		TTL     Duration `json:"ttl"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*cec = CacheEntityConfig{
+		Disabled: raw.Enabled != nil && !*raw.Enabled,
+		TTL:      raw.TTL,
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "1h30m". Whole days
+// may also be written with a "d" suffix, e.g. "7d".
+type Duration time.Duration
+
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
 }
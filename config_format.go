@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A configFormat parses a config file into a generic tree, along with the line
+// on which each key was found.
+//
+// Keys are written as dotted paths with array indices in brackets, such as
+// "providers.splitwise.options.category_mapping[0].name".
+type configFormat func(data []byte) (map[string]interface{}, map[string]int, error)
+
+// configFormatFor selects the format of a config file from its extension.
+func configFormatFor(path string) (configFormat, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", "":
+		return parseJSONConfig, nil
+	case ".toml":
+		return parseTOMLConfig, nil
+	case ".yaml", ".yml":
+		return parseYAMLConfig, nil
+	default:
+		return nil, fmt.Errorf("unsupported config format '%s'", ext)
+	}
+}
+
+// formatConfig encodes a generic config tree in the format chosen by the
+// extension of path.
+func formatConfig(path string, tree map[string]interface{}) ([]byte, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", "":
+		data, err := json.MarshalIndent(tree, "", "    ")
+		return append(data, '\n'), err
+	case ".toml":
+		t, err := toml.TreeFromMap(tree)
+		if err != nil {
+			return nil, err
+		}
+		return t.Marshal()
+	case ".yaml", ".yml":
+		return yaml.Marshal(tree)
+	default:
+		return nil, fmt.Errorf("unsupported config format '%s'", ext)
+	}
+}
+
+// configError is an error at a particular key within the config.
+type configError struct {
+	Key string
+	Err error
+}
+
+func (ce *configError) Error() string {
+	return fmt.Sprintf("%s: %s", ce.Key, ce.Err)
+}
+
+func (ce *configError) Unwrap() error {
+	return ce.Err
+}
+
+// keyError attributes err to key, which is prefixed to the key of any error
+// found further within it.
+func keyError(key string, err error) error {
+	var ce *configError
+	if errors.As(err, &ce) {
+		return &configError{Key: joinKey(key, ce.Key), Err: ce.Err}
+	}
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		// The decoder reports the field path from wherever it started
+		// decoding, with array indices as plain path elements.
+		rel := te.Field
+		if rel == key {
+			rel = ""
+		} else if strings.HasPrefix(rel, key+".") {
+			rel = strings.TrimPrefix(rel, key+".")
+		}
+		field := key
+		for _, part := range strings.Split(rel, ".") {
+			if _, err := strconv.Atoi(part); err == nil {
+				field += "[" + part + "]"
+			} else if part != "" {
+				field = joinKey(field, part)
+			}
+		}
+		return &configError{
+			Key: field,
+			Err: fmt.Errorf("cannot use a %s value as %s", te.Value, te.Type),
+		}
+	}
+	return &configError{Key: key, Err: err}
+}
+
+// locate formats err with the file and line of the key it occurred at.
+func locate(path string, positions map[string]int, err error) error {
+	var ce *configError
+	if !errors.As(err, &ce) {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	// Fall back to the closest enclosing key with a known position.
+	for key := ce.Key; key != ""; key = parentKey(key) {
+		if line, ok := positions[key]; ok {
+			return fmt.Errorf("%s:%d: %s", path, line, ce)
+		}
+	}
+	return fmt.Errorf("%s: %s", path, ce)
+}
+
+func parentKey(key string) string {
+	i := strings.LastIndexAny(key, ".[")
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+func parseJSONConfig(data []byte) (map[string]interface{}, map[string]int, error) {
+	var tree map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tree); err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return nil, nil, fmt.Errorf("line %d: %s", lineAt(data, se.Offset), err)
+		}
+		return nil, nil, err
+	}
+
+	positions := make(map[string]int)
+	decoder = json.NewDecoder(bytes.NewReader(data))
+	var walk func(key string) error
+	walk = func(key string) error {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for decoder.More() {
+				tok, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				child := joinKey(key, tok.(string))
+				positions[child] = lineAt(data, decoder.InputOffset())
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", key, i)); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+	return tree, positions, walk("")
+}
+
+func parseTOMLConfig(data []byte) (map[string]interface{}, map[string]int, error) {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	positions := make(map[string]int)
+	var walk func(prefix string, t *toml.Tree)
+	walk = func(prefix string, t *toml.Tree) {
+		for _, k := range t.Keys() {
+			key := joinKey(prefix, k)
+			path := []string{k}
+			switch v := t.GetPath(path).(type) {
+			case *toml.Tree:
+				positions[key] = v.Position().Line
+				walk(key, v)
+			case []*toml.Tree:
+				for i, elem := range v {
+					elemKey := fmt.Sprintf("%s[%d]", key, i)
+					positions[elemKey] = elem.Position().Line
+					walk(elemKey, elem)
+				}
+				if len(v) > 0 {
+					positions[key] = v[0].Position().Line
+				}
+			default:
+				positions[key] = t.GetPositionPath(path).Line
+			}
+		}
+	}
+	walk("", tree)
+	return tree.ToMap(), positions, nil
+}
+
+func parseYAMLConfig(data []byte) (map[string]interface{}, map[string]int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	var tree map[string]interface{}
+	if err := doc.Decode(&tree); err != nil {
+		return nil, nil, err
+	}
+	positions := make(map[string]int)
+	var walk func(key string, node *yaml.Node)
+	walk = func(key string, node *yaml.Node) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(key, child)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				child := joinKey(key, node.Content[i].Value)
+				positions[child] = node.Content[i].Line
+				walk(child, node.Content[i+1])
+			}
+		case yaml.SequenceNode:
+			for i, elem := range node.Content {
+				child := fmt.Sprintf("%s[%d]", key, i)
+				positions[child] = elem.Line
+				walk(child, elem)
+			}
+		}
+	}
+	walk("", &doc)
+	return tree, positions, nil
+}
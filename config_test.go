@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	r := require.New(t)
+
+	configs := map[string]string{
+		"config.json": `{
+			"budget_id": "budget",
+			"access_token": "token",
+			"lookback_days": 30,
+			"providers": {
+				"splitwise": {
+					"account_id": "account",
+					"options": {
+						"user_id": 123,
+						"category_mapping": [{"name": "Groceries", "ynab_name": "Food"}]
+					}
+				}
+			}
+		}`,
+		"config.toml": `
+budget_id = "budget"
+access_token = "token"
+lookback_days = 30
+
+[providers.splitwise]
+account_id = "account"
+
+[providers.splitwise.options]
+user_id = 123
+
+[[providers.splitwise.options.category_mapping]]
+name = "Groceries"
+ynab_name = "Food"
+`,
+		"config.yaml": `
+budget_id: budget
+access_token: token
+lookback_days: 30
+providers:
+  splitwise:
+    account_id: account
+    options:
+      user_id: 123
+      category_mapping:
+        - name: Groceries
+          ynab_name: Food
+`,
+	}
+	for name, contents := range configs {
+		config, err := loadConfig(writeConfig(t, name, contents))
+		r.NoError(err, name)
+		r.Equal("budget", *config.BudgetID, name)
+		r.Equal("token", config.AccessToken, name)
+		r.Equal(int64(30), config.LookBackDays, name)
+
+		provider := config.Providers.Map["splitwise"]
+		r.Equal("account", provider.AccountID, name)
+		options := provider.Options.(*SplitwiseOptions)
+		r.Equal(123, *options.UserID, name)
+		r.Equal("Food", options.CategoryMapping["Groceries"].YnabName, name)
+	}
+}
+
+func TestLoadConfigErrorLocation(t *testing.T) {
+	r := require.New(t)
+
+	path := writeConfig(t, "config.yaml", `
+access_token: token
+providers:
+  splitwise:
+    account_id: account
+    options:
+      user_id: "not a number"
+`)
+	_, err := loadConfig(path)
+	r.EqualError(err, path+":7: providers.splitwise.options.user_id: cannot use a string value as int")
+
+	path = writeConfig(t, "config.toml", `
+access_token = "token"
+
+[providers.venmo]
+account_id = "account"
+`)
+	_, err = loadConfig(path)
+	r.EqualError(err, path+":4: providers.venmo: unknown provider")
+
+	path = writeConfig(t, "config.json", `{
+	"access_token": "token",
+	"lookback_days": "thirty"
+}`)
+	_, err = loadConfig(path)
+	r.EqualError(err, path+":3: lookback_days: cannot use a string value as int64")
+}
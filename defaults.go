@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"budgetbridge/ynab"
+)
+
+// TransactionDefaults are set on every transaction of a provider before any
+// rules apply, such as to flag everything which came from it.
+type TransactionDefaults struct {
+	Cleared   ynab.ClearedStatus `json:"cleared"`
+	Approved  *bool              `json:"approved"`
+	FlagColor ynab.FlagColor     `json:"flag_color"`
+}
+
+func (d TransactionDefaults) validate() error {
+	switch {
+	case d.Cleared != "" && !d.Cleared.Valid():
+		return fmt.Errorf("unknown cleared status '%s', expected one of %s", d.Cleared, clearedStatusNames())
+	case d.FlagColor != "" && !d.FlagColor.Valid():
+		return fmt.Errorf("unknown flag_color '%s', expected one of %s", d.FlagColor, flagColorNames())
+	}
+	return nil
+}
+
+// apply sets the defaults on a transaction.
+func (d TransactionDefaults) apply(t *ynab.Transaction) {
+	if d.Cleared != "" {
+		t.Cleared = d.Cleared
+	}
+	if d.Approved != nil {
+		t.Approved = *d.Approved
+	}
+	if d.FlagColor != "" {
+		color := d.FlagColor
+		t.FlagColor = &color
+	}
+}
+
+// describe lists the defaults which are set, or returns an empty string if
+// there are none.
+func (d TransactionDefaults) describe() string {
+	var set []string
+	if d.Cleared != "" {
+		set = append(set, fmt.Sprintf("cleared '%s'", d.Cleared))
+	}
+	if d.Approved != nil {
+		set = append(set, "approved "+yesNo(*d.Approved))
+	}
+	if d.FlagColor != "" {
+		set = append(set, fmt.Sprintf("flag '%s'", d.FlagColor))
+	}
+	return strings.Join(set, ", ")
+}
+
+func clearedStatusNames() string {
+	names := make([]string, len(ynab.ClearedStatuses))
+	for i, status := range ynab.ClearedStatuses {
+		names[i] = string(status)
+	}
+	return strings.Join(names, ", ")
+}
+
+func flagColorNames() string {
+	names := make([]string, len(ynab.FlagColors))
+	for i, color := range ynab.FlagColors {
+		names[i] = string(color)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionDefaults(t *testing.T) {
+	r := require.New(t)
+
+	approved := true
+	defaults := TransactionDefaults{Cleared: ynab.Cleared, Approved: &approved, FlagColor: ynab.FlagPurple}
+	r.NoError(defaults.validate())
+	r.Equal("cleared 'cleared', approved yes, flag 'purple'", defaults.describe())
+
+	var tx ynab.Transaction
+	defaults.apply(&tx)
+	r.Equal(ynab.Cleared, tx.Cleared)
+	r.True(tx.Approved)
+	r.Equal(ynab.FlagPurple, *tx.FlagColor)
+
+	r.Empty(TransactionDefaults{}.describe())
+	r.EqualError(TransactionDefaults{FlagColor: "pink"}.validate(),
+		"unknown flag_color 'pink', expected one of red, orange, yellow, green, blue, purple")
+	r.EqualError(TransactionDefaults{Cleared: "maybe"}.validate(),
+		"unknown cleared status 'maybe', expected one of cleared, uncleared, reconciled")
+
+	path := writeConfig(t, "config.yaml", `
+access_token: token
+providers:
+  splitwise:
+    account_id: account
+    defaults:
+      flag_color: pink
+`)
+	_, err := loadConfig(path)
+	r.Error(err)
+	r.Contains(err.Error(), "providers.splitwise.defaults: unknown flag_color 'pink'")
+}
+
+func TestImportAllDefaults(t *testing.T) {
+	r := require.New(t)
+
+	var rules Rules
+	r.NoError(json.Unmarshal([]byte(`[{"match": {"category": "electricity"}, "set": {"flag_color": "", "cleared": "uncleared"}}]`), &rules))
+
+	approved := true
+	fake := &fakeYNAB{}
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: fake,
+		providers: []NamedProvider{{
+			Name:      "splitwise",
+			AccountID: "account",
+			Defaults:  TransactionDefaults{Cleared: ynab.Cleared, Approved: &approved, FlagColor: ynab.FlagPurple},
+			TransactionProvider: &SplitwiseTransactionProvider{
+				userID: 456,
+				client: &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+			},
+		}},
+		rules:  rules,
+		Since:  date(2020, 1, 1),
+		output: &bytes.Buffer{},
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+	r.Len(fake.created, 1)
+	r.Len(fake.created[0], 3)
+	for _, tx := range fake.created[0] {
+		r.True(tx.Approved, tx.ImportId)
+		if tx.Amount == 67020 {
+			r.Equal(ynab.Uncleared, tx.Cleared, "rules override the defaults")
+			r.Nil(tx.FlagColor, "an empty flag_color clears the flag")
+			continue
+		}
+		r.Equal(ynab.Cleared, tx.Cleared, tx.ImportId)
+		r.Equal(ynab.FlagPurple, *tx.FlagColor, tx.ImportId)
+	}
+}
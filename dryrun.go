@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"budgetbridge/ynab"
+)
+
+// A planAction is what a real run would do with a transaction.
+type planAction string
+
+const (
+	// The transaction would be created.
+	planCreate planAction = "create"
+	// The transaction was already imported and hasn't changed, so YNAB would
+	// ignore it.
+	planDuplicate planAction = "duplicate"
+	// The transaction was already imported but has since changed at its
+	// source. YNAB ignores it like any other duplicate import ID.
+	planUpdate planAction = "update"
+	// The transaction would be created, but looks like a transaction which
+	// was entered some other way.
+	planConflict planAction = "conflict"
+	// The transaction was dropped by a rule.
+	planDrop planAction = "drop"
+)
+
+// plannedTransaction describes what would happen to a transaction.
+type plannedTransaction struct {
+	Action      planAction        `json:"action"`
+	Transaction ynab.Transaction  `json:"transaction"`
+	Existing    *ynab.Transaction `json:"existing,omitempty"`
+	Changes     []fieldChange     `json:"changes,omitempty"`
+	// Rules are the rules which fired for the transaction.
+	Rules []string `json:"rules,omitempty"`
+	// Suggestion is the category suggested for the transaction, if any.
+	Suggestion *Suggestion `json:"suggestion,omitempty"`
+}
+
+// fieldChange is a difference between an existing transaction and the one
+// that would be imported.
+type fieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// conflictSlack is how far apart the dates of two transactions with the same
+// amount may be for them to be considered the same.
+const conflictSlack = 3 * 24 * time.Hour
+
+// planTransactions classifies each candidate against the existing
+// transactions in the same account.
+func planTransactions(candidates, existing []ynab.Transaction, categoryName func(*string) string) []plannedTransaction {
+	type key struct{ account, importID string }
+	byImportID := make(map[key]*ynab.Transaction)
+	for i := range existing {
+		t := &existing[i]
+		if t.ImportId != nil && !t.Deleted {
+			byImportID[key{t.AccountId, *t.ImportId}] = t
+		}
+	}
+
+	plan := make([]plannedTransaction, 0, len(candidates))
+	for _, c := range candidates {
+		p := plannedTransaction{Action: planCreate, Transaction: c}
+		if c.ImportId != nil {
+			p.Existing = byImportID[key{c.AccountId, *c.ImportId}]
+		}
+		if p.Existing != nil {
+			p.Changes = diffTransactions(*p.Existing, c, categoryName)
+			p.Action = planDuplicate
+			if len(p.Changes) > 0 {
+				p.Action = planUpdate
+			}
+		} else if match := findSimilar(existing, c); match != nil {
+			p.Action = planConflict
+			p.Existing = match
+		}
+		plan = append(plan, p)
+	}
+	return plan
+}
+
+// findSimilar returns an existing transaction with the same amount on about
+// the same date which wasn't imported by the bridge.
+func findSimilar(existing []ynab.Transaction, c ynab.Transaction) *ynab.Transaction {
+	for i := range existing {
+		t := &existing[i]
+		if t.Deleted || t.AccountId != c.AccountId || t.Amount != c.Amount {
+			continue
+		}
+		if t.ImportId != nil && c.ImportId != nil && *t.ImportId == *c.ImportId {
+			continue
+		}
+		delta := t.Date.Time().Sub(c.Date.Time())
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta <= conflictSlack {
+			return t
+		}
+	}
+	return nil
+}
+
+func diffTransactions(from, to ynab.Transaction, categoryName func(*string) string) []fieldChange {
+	var changes []fieldChange
+	if from.Amount != to.Amount {
+		changes = append(changes, fieldChange{"amount", formatMilliUnits(from.Amount), formatMilliUnits(to.Amount)})
+	}
+	if fromCategory, toCategory := transactionCategory(from, categoryName), transactionCategory(to, categoryName); fromCategory != toCategory {
+		changes = append(changes, fieldChange{"category", fromCategory, toCategory})
+	}
+	if from.PayeeName != to.PayeeName {
+		changes = append(changes, fieldChange{"payee", from.PayeeName, to.PayeeName})
+	}
+	if from.Memo != to.Memo {
+		changes = append(changes, fieldChange{"memo", from.Memo, to.Memo})
+	}
+	return changes
+}
+
+// transactionCategory names the category of a transaction, or the category
+// and amount of each part if it's split.
+func transactionCategory(tx ynab.Transaction, categoryName func(*string) string) string {
+	if len(tx.Subtransactions) == 0 {
+		return categoryName(tx.CategoryId)
+	}
+	parts := make([]string, len(tx.Subtransactions))
+	for i, sub := range tx.Subtransactions {
+		category := categoryName(sub.CategoryId)
+		if category == "" {
+			category = "-"
+		}
+		parts[i] = fmt.Sprintf("%s %s", category, formatMilliUnits(sub.Amount))
+	}
+	return "split: " + strings.Join(parts, ", ")
+}
+
+// existingTransactions fetches the transactions in every account the
+// candidates would be created in, going back as far as the oldest of them.
+func (bb BudgetBridge) existingTransactions(ctx context.Context, candidates []ynab.Transaction) ([]ynab.Transaction, error) {
+	oldest := make(map[string]time.Time)
+	for _, c := range candidates {
+		if since, ok := oldest[c.AccountId]; !ok || c.Date.Time().Before(since) {
+			oldest[c.AccountId] = c.Date.Time()
+		}
+	}
+	var existing []ynab.Transaction
+	for accountID, since := range oldest {
+		res, err := bb.ynabClient.Transactions(ctx, ynab.TransactionsRequest{
+			BudgetID:  bb.BudgetID,
+			AccountID: accountID,
+			SinceDate: since.Add(-conflictSlack),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fetch existing transactions: %s", err)
+		}
+		for _, t := range res.Transactions {
+			// The account isn't always set when listing by account.
+			t.AccountId = accountID
+			existing = append(existing, t)
+		}
+	}
+	return existing, nil
+}
+
+// writePlan writes the planned transactions as a table or as JSON, along with
+// how many records were filtered out.
+func writePlan(w io.Writer, format string, plan []plannedTransaction, filtered int, categoryName func(*string) string) error {
+	t := newTable("ACTION", "DATE", "PAYEE", "MEMO", "AMOUNT", "CATEGORY", "IMPORT ID", "RULES", "SUGGESTION")
+	counts := make(map[planAction]int)
+	for _, p := range plan {
+		counts[p.Action]++
+		tx := p.Transaction
+		amount := formatMilliUnits(tx.Amount)
+		category := transactionCategory(tx, categoryName)
+		payee, memo := tx.PayeeName, tx.Memo
+		for _, c := range p.Changes {
+			change := fmt.Sprintf("%s -> %s", c.From, c.To)
+			switch c.Field {
+			case "amount":
+				amount = change
+			case "category":
+				category = change
+			case "payee":
+				payee = change
+			case "memo":
+				memo = change
+			}
+		}
+		if category == "" {
+			category = "-"
+		}
+		var importID string
+		if tx.ImportId != nil {
+			importID = *tx.ImportId
+		}
+		rules := strings.Join(p.Rules, ", ")
+		if rules == "" {
+			rules = "-"
+		}
+		suggestion := "-"
+		if s := p.Suggestion; s != nil {
+			suggestion = fmt.Sprintf("%s %s", categoryName(&s.CategoryID), s)
+			if s.Applied {
+				suggestion += " (applied)"
+			}
+		}
+		t.add(p.Action, tx.Date.String(), payee, memo, amount, category, importID, rules, suggestion)
+	}
+	if err := writeOutput(w, format, t, plan); err != nil {
+		return err
+	}
+	if format == formatTable {
+		fmt.Fprintf(w, "\n%d to create, %d duplicate, %d updated at source, %d conflicting, %d dropped, %d filtered\n",
+			counts[planCreate], counts[planDuplicate], counts[planUpdate], counts[planConflict], counts[planDrop], filtered)
+	}
+	return nil
+}
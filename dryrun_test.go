@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanTransactions(t *testing.T) {
+	r := require.New(t)
+
+	categoryName := func(id *string) string {
+		if id == nil {
+			return ""
+		}
+		return map[string]string{"food": "Groceries", "fun": "Fun Money"}[*id]
+	}
+	tx := func(importID string, day, amount int, category string) ynab.Transaction {
+		t := ynab.Transaction{
+			AccountId: "account",
+			Date:      ynab.Date(date(2020, 8, day)),
+			Amount:    amount,
+			PayeeName: "Annie",
+			Memo:      "Dinner",
+		}
+		if importID != "" {
+			t.ImportId = &importID
+		}
+		if category != "" {
+			t.CategoryId = &category
+		}
+		return t
+	}
+	existing := []ynab.Transaction{
+		tx("1", 1, -10000, "food"),
+		tx("2", 2, -20000, "food"),
+		tx("", 10, -30000, ""),
+		tx("4", 12, -40000, ""),
+	}
+	existing[3].Deleted = true
+	candidates := []ynab.Transaction{
+		tx("1", 1, -10000, "food"),
+		tx("2", 2, -25000, "fun"),
+		tx("3", 11, -30000, ""),
+		tx("4", 12, -40000, ""),
+	}
+
+	plan := planTransactions(candidates, existing, categoryName)
+	r.Len(plan, 4)
+	r.Equal(planDuplicate, plan[0].Action)
+	r.Equal(planUpdate, plan[1].Action)
+	r.Equal([]fieldChange{
+		{"amount", "-20.00", "-25.00"},
+		{"category", "Groceries", "Fun Money"},
+	}, plan[1].Changes)
+	r.Equal(planConflict, plan[2].Action)
+	r.Equal(&existing[2], plan[2].Existing)
+	r.Equal(planCreate, plan[3].Action, "deleted transactions don't count")
+
+	plan[2].Suggestion = &Suggestion{CategoryID: "fun", Confidence: 0.75}
+	plan[3].Rules = []string{"dining"}
+	var out bytes.Buffer
+	r.NoError(writePlan(&out, formatTable, plan, 2, categoryName))
+	r.Equal(`ACTION     DATE        PAYEE  MEMO    AMOUNT            CATEGORY                IMPORT ID  RULES   SUGGESTION
+duplicate  2020-08-01  Annie  Dinner  -10.00            Groceries               1          -       -
+update     2020-08-02  Annie  Dinner  -20.00 -> -25.00  Groceries -> Fun Money  2          -       -
+conflict   2020-08-11  Annie  Dinner  -30.00            -                       3          -       Fun Money 75%
+create     2020-08-12  Annie  Dinner  -40.00            -                       4          dining  -
+
+1 to create, 1 duplicate, 1 updated at source, 1 conflicting, 0 dropped, 2 filtered
+`, out.String())
+}
+
+func TestImportAllDryRun(t *testing.T) {
+	r := require.New(t)
+
+	importID := "1"
+	client := &fakeYNAB{
+		transactions: ynab.TransactionsResponse{
+			Transactions: []ynab.Transaction{{Memo: "other", Amount: -12340, ImportId: &importID}},
+		},
+	}
+	var out bytes.Buffer
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: client,
+		providers: []NamedProvider{
+			{Name: "fake", AccountID: "account", TransactionProvider: &fakeProvider{}},
+		},
+		dryRun:       true,
+		output:       &out,
+		outputFormat: formatTable,
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+	r.Empty(client.created)
+	r.Contains(out.String(), "1 to create, 0 duplicate")
+}
+
+func TestTransactionCategory(t *testing.T) {
+	categoryName := func(id *string) string {
+		if id == nil {
+			return ""
+		}
+		return "Utilities"
+	}
+	utilities := "utilities"
+	tx := ynab.Transaction{Amount: 67020, Subtransactions: []ynab.SubTransaction{
+		{Amount: -67020, CategoryId: &utilities},
+		{Amount: 134040},
+	}}
+	require.Equal(t, "split: Utilities -67.02, - 134.04", transactionCategory(tx, categoryName))
+	require.Equal(t, "", transactionCategory(ynab.Transaction{}, categoryName))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"budgetbridge/ynab"
+)
+
+// An explanation describes each step taken to turn a source record into a
+// YNAB transaction.
+type explanation struct {
+	Provider string        `json:"provider"`
+	ID       string        `json:"id"`
+	Steps    []explainStep `json:"steps"`
+	// Transaction is what would be created, unless the record was skipped
+	// or dropped.
+	Transaction *ynab.Transaction `json:"transaction,omitempty"`
+	// Action, Existing and Changes describe the transaction against YNAB as
+	// in a dry run.
+	Action   planAction        `json:"action,omitempty"`
+	Existing *ynab.Transaction `json:"existing,omitempty"`
+	Changes  []fieldChange     `json:"changes,omitempty"`
+}
+
+type explainStep struct {
+	Step   string `json:"step"`
+	Detail string `json:"detail"`
+}
+
+// add records a step. It does nothing if ex is nil, so that providers can
+// share their code with and without an explanation.
+func (ex *explanation) add(step, format string, args ...interface{}) {
+	if ex == nil {
+		return
+	}
+	ex.Steps = append(ex.Steps, explainStep{step, fmt.Sprintf(format, args...)})
+}
+
+func runExplain(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	args = parseArgs(fs, args)
+	if len(args) != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a provider and the ID of a record")
+	}
+	providerName, id := args[0], args[1]
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx = withVault(ctx, config.vault)
+	// Only the provider being explained is initialized.
+	providerConfig, ok := config.Providers.Map[providerName]
+	if !ok {
+		return fmt.Errorf("provider '%s' is not configured", providerName)
+	}
+	config.Providers.Map = map[string]ProviderConfig{providerName: providerConfig}
+
+	ynabClient, err := openCachingClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer closeCache(ynabClient.cache)
+	bridge, err := newBudgetBridge(ctx, config, ynabClient)
+	if err != nil {
+		return err
+	}
+	res, err := ynabClient.Accounts(ctx, bridge.BudgetID)
+	if err != nil {
+		return fmt.Errorf("fetch accounts: %s", err)
+	}
+
+	ex, err := bridge.explain(ctx, res.Accounts, providerName, id)
+	if len(ex.Steps) > 0 {
+		if err := writeExplanation(os.Stdout, *format, ex, bridge.categories.Name); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// explain traces a single record through the same steps as a sync: its
+// conversion by the provider, the account it's routed to, the include and
+// exclude lists of the provider, the state kept from reviews, the suggested
+// category, the rules, and finally what YNAB already has.
+func (bb BudgetBridge) explain(ctx context.Context, accounts []ynab.Account, providerName, id string) (explanation, error) {
+	ex := explanation{Provider: providerName, ID: id}
+	var provider NamedProvider
+	for _, p := range bb.providers {
+		if p.Name == providerName {
+			provider = p
+		}
+	}
+	if provider.TransactionProvider == nil {
+		return ex, fmt.Errorf("provider '%s' could not be initialized", providerName)
+	}
+	explainer, ok := provider.TransactionProvider.(Explainer)
+	if !ok {
+		return ex, fmt.Errorf("provider '%s' cannot explain its records", providerName)
+	}
+	t, err := explainer.Explain(ctx, YnabInfo{Categories: bb.categories}, id, &ex)
+	if err != nil {
+		return ex, err
+	}
+	t.AccountId = provider.AccountID
+	t.Source.Provider = provider.Name
+	ex.add("routing", "provider '%s' imports into account %s", provider.Name, accountName(accounts, t.AccountId))
+	if reason := provider.filterReason(t.Source); reason != "" {
+		ex.add("filter", "filtered out: %s", reason)
+		return ex, nil
+	}
+	if defaults := provider.Defaults.describe(); defaults != "" {
+		provider.Defaults.apply(&t.Transaction)
+		ex.add("defaults", "provider '%s' sets %s", provider.Name, defaults)
+	}
+
+	categoryName := bb.categories.Name
+	if bb.state.isIgnored(t.Source.Provider, t.Source.ID) {
+		ex.add("state", "skipped permanently during an earlier review")
+		return ex, nil
+	}
+	before := categoryName(t.CategoryId)
+	t = bb.state.apply([]SourceTransaction{t})[0]
+	if after := categoryName(t.CategoryId); after != before {
+		ex.add("state", "category '%s' was remembered for '%s' during an earlier review", after, t.Source.categoryPath())
+	}
+
+	suggest, err := bb.loadSuggester(ctx)
+	if err != nil {
+		return ex, err
+	}
+	t = suggest.apply([]SourceTransaction{t})[0]
+	switch s := t.Suggestion; {
+	case s != nil && s.Applied:
+		ex.add("suggestions", "category '%s' was suggested with %s confidence and applied", categoryName(&s.CategoryID), s)
+	case s != nil:
+		ex.add("suggestions", "category '%s' was suggested with %s confidence, below the auto_apply threshold of %.0f%%",
+			categoryName(&s.CategoryID), s, bb.suggestions.AutoApply*100)
+	case suggest != nil && (t.CategoryId == nil || t.Source.Unmapped):
+		ex.add("suggestions", "nothing like it was imported before")
+	}
+
+	accountID := t.AccountId
+	kept, dropped := bb.rules.apply([]SourceTransaction{t})
+	switch {
+	case len(dropped) > 0:
+		ex.add("rules", "dropped by %s", strings.Join(quoteAll(dropped[0].Trace), ", then "))
+		return ex, nil
+	case len(kept[0].Trace) == 0:
+		ex.add("rules", "no rules matched")
+	default:
+		t = kept[0]
+		ex.add("rules", "applied %s", strings.Join(quoteAll(t.Trace), ", then "))
+		if t.AccountId != accountID {
+			ex.add("routing", "rules moved it to account %s", accountName(accounts, t.AccountId))
+		}
+	}
+	tx := t.ynabTransaction()
+	ex.Transaction = &tx
+
+	existing, err := bb.existingTransactions(ctx, []ynab.Transaction{tx})
+	if err != nil {
+		return ex, err
+	}
+	plan := planTransactions([]ynab.Transaction{tx}, existing, categoryName)[0]
+	ex.Action, ex.Existing, ex.Changes = plan.Action, plan.Existing, plan.Changes
+	switch plan.Action {
+	case planCreate:
+		ex.add("ynab", "not in YNAB yet, so it would be created")
+	case planDuplicate:
+		ex.add("ynab", "already imported as transaction %s", plan.Existing.Id)
+	case planUpdate:
+		ex.add("ynab", "already imported as transaction %s, but has since changed at its source", plan.Existing.Id)
+	case planConflict:
+		ex.add("ynab", "would be created, but looks like transaction %s dated %s which was entered some other way",
+			plan.Existing.Id, plan.Existing.Date.String())
+	}
+	return ex, nil
+}
+
+func accountName(accounts []ynab.Account, id string) string {
+	for _, a := range accounts {
+		if a.Id == id {
+			return fmt.Sprintf("'%s' (%s)", a.Name, a.Id)
+		}
+	}
+	return fmt.Sprintf("'%s'", id)
+}
+
+func writeExplanation(w io.Writer, format string, ex explanation, categoryName func(*string) string) error {
+	t := newTable("STEP", "DETAIL")
+	for _, s := range ex.Steps {
+		t.add(s.Step, s.Detail)
+	}
+	if tx := ex.Transaction; tx != nil {
+		category := transactionCategory(*tx, categoryName)
+		if category == "" {
+			category = "-"
+		}
+		var flag string
+		if tx.Cleared != "" {
+			flag = ", " + string(tx.Cleared)
+		}
+		if tx.FlagColor != nil {
+			flag += ", flagged " + string(*tx.FlagColor)
+		}
+		t.add("transaction", fmt.Sprintf("%s %s to '%s' memo '%s' in category %s, approved %s%s",
+			tx.Date.String(), formatMilliUnits(tx.Amount), tx.PayeeName, tx.Memo, category, yesNo(tx.Approved), flag))
+	}
+	for _, c := range ex.Changes {
+		t.add("change", fmt.Sprintf("%s: %s -> %s", c.Field, c.From, c.To))
+	}
+	return writeOutput(w, format, t, ex)
+}
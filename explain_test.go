@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	r := require.New(t)
+
+	importID := "1"
+	client := &fakeYNAB{transactions: ynab.TransactionsResponse{Transactions: []ynab.Transaction{
+		{Id: "existing", AccountId: "account", ImportId: &importID, Amount: -75000, Memo: "Groceries", PayeeName: "Annie"},
+	}}}
+	provider := &SplitwiseTransactionProvider{
+		userID: 456,
+		client: &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+		categoryMapping: CategoryMapping{
+			"Groceries": {Name: "Groceries", YnabName: "Food", YnabId: "food"},
+		},
+	}
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: client,
+		providers: []NamedProvider{
+			{Name: "splitwise", AccountID: "account", TransactionProvider: provider},
+		},
+		categories: newCategoryIndex([]ynab.CategoryGroup{
+			{Name: "Everyday", Categories: []ynab.Category{{Id: "food", Name: "Food"}}},
+		}),
+		rules: Rules{
+			{Name: "apartment", Match: RuleMatch{Group: "apartment"}, Set: RuleSet{Approved: boolPtr(true)}},
+		},
+	}
+	accounts := []ynab.Account{{Id: "account", Name: "Splitwise"}}
+	ex, err := bb.explain(context.Background(), accounts, "splitwise", "1")
+	r.NoError(err)
+
+	var steps []string
+	for _, s := range ex.Steps {
+		steps = append(steps, s.Step+": "+s.Detail)
+	}
+	r.Equal([]string{
+		"expense: 'Groceries' on 2020-08-09 costing 150.0 USD, category 'Groceries' (12)",
+		"users: user 456 is Jeff Winger: paid 0.0, owes 75.0, net balance -75.0, other users are Annie Edison: paid 150.0, owes 75.0, net balance 75.0",
+		"amount: net balance '-75.0' is -75000 milliunits (-75.00)",
+		"category: category_mapping 'Groceries' (matched by name) names YNAB category 'Food', which has ID 'food'",
+		"group: 'Apartment' (42)",
+		"routing: provider 'splitwise' imports into account 'Splitwise' (account)",
+		"rules: applied 'apartment'",
+		"ynab: already imported as transaction existing, but has since changed at its source",
+	}, steps)
+	r.Equal(planUpdate, ex.Action)
+	r.Equal([]fieldChange{{"category", "", "Food"}}, ex.Changes)
+	r.True(ex.Transaction.Approved)
+
+	var out bytes.Buffer
+	r.NoError(writeExplanation(&out, formatTable, ex, bb.categories.Name))
+	r.Contains(out.String(), "transaction  2020-08-09 -75.00 to 'Annie' memo 'Groceries' in category Food, approved yes\n")
+
+	_, err = bb.explain(context.Background(), accounts, "splitwise", "9")
+	r.EqualError(err, "get_expense: no expense 9")
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
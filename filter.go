@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A RecordFilter lists the categories, groups and friends of the source
+// records of a provider. Names are compared without regard to case.
+type RecordFilter struct {
+	// Categories match either the category of a record or its parent.
+	Categories []string `json:"categories"`
+	Groups     []string `json:"groups"`
+	// Friends match either the full name or the first name of the person a
+	// record is shared with.
+	Friends []string `json:"friends"`
+}
+
+// filterReason returns why a record of the provider is left out by its
+// include and exclude lists, or an empty string if it's kept.
+//
+// A record is kept if it matches every include list which isn't empty, and
+// none of the exclude lists.
+func (np NamedProvider) filterReason(r SourceRecord) string {
+	checks := []struct {
+		field            string
+		value            string
+		include, exclude []string
+		// match returns the value of the record which the name matches, or
+		// an empty string if it matches none.
+		match func(name string) string
+	}{
+		{"category", r.Category, np.Include.Categories, np.Exclude.Categories, func(name string) string {
+			return matchName(name, r.Category, r.ParentCategory)
+		}},
+		{"group", r.Group, np.Include.Groups, np.Exclude.Groups, func(name string) string {
+			return matchName(name, r.Group)
+		}},
+		{"friend", r.Friend, np.Include.Friends, np.Exclude.Friends, func(name string) string {
+			if first := strings.Fields(r.Friend); len(first) > 0 && strings.EqualFold(name, first[0]) {
+				return r.Friend
+			}
+			return matchName(name, r.Friend)
+		}},
+	}
+	for _, c := range checks {
+		if len(c.include) > 0 && anyName(c.include, c.match) == "" {
+			if c.value == "" {
+				return fmt.Sprintf("without a %s", c.field)
+			}
+			return fmt.Sprintf("%s '%s' is not included", c.field, c.value)
+		}
+		if matched := anyName(c.exclude, c.match); matched != "" {
+			return fmt.Sprintf("%s '%s' is excluded", c.field, matched)
+		}
+	}
+	return ""
+}
+
+// matchName returns the first of the values which is name regardless of case,
+// or an empty string if none is.
+func matchName(name string, values ...string) string {
+	for _, v := range values {
+		if v != "" && strings.EqualFold(name, v) {
+			return v
+		}
+	}
+	return ""
+}
+
+// anyName returns the value matched by the first of the names which matches
+// one, or an empty string if none do.
+func anyName(names []string, match func(string) string) string {
+	for _, name := range names {
+		if matched := match(name); matched != "" {
+			return matched
+		}
+	}
+	return ""
+}
+
+// filterCounts holds how many records were left out for each reason, by
+// provider.
+type filterCounts map[string]map[string]int
+
+// check returns why a record is filtered out by the provider, or an empty
+// string if it's kept, counting it by reason.
+func (fc filterCounts) check(provider NamedProvider, r SourceRecord) string {
+	reason := provider.filterReason(r)
+	if reason == "" {
+		return ""
+	}
+	log.Debug().
+		Str("provider", provider.Name).
+		Str("id", r.ID).
+		Str("reason", reason).
+		Msg("record filtered out")
+	if fc[provider.Name] == nil {
+		fc[provider.Name] = make(map[string]int)
+	}
+	fc[provider.Name][reason]++
+	return reason
+}
+
+// filter leaves out the transactions whose records are filtered out by the
+// provider, counting them by reason.
+func (fc filterCounts) filter(provider NamedProvider, transactions []SourceTransaction) []SourceTransaction {
+	kept := transactions[:0]
+	for _, t := range transactions {
+		if fc.check(provider, t.Source) == "" {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// total returns how many records were left out in all.
+func (fc filterCounts) total() int {
+	var n int
+	for _, reasons := range fc {
+		for _, count := range reasons {
+			n += count
+		}
+	}
+	return n
+}
+
+// reportFiltered lists how many records each provider left out, and why.
+func (bb BudgetBridge) reportFiltered(filtered filterCounts) {
+	providers := make([]string, 0, len(filtered))
+	for provider := range filtered {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	for _, provider := range providers {
+		reasons := make([]string, 0, len(filtered[provider]))
+		var n int
+		for reason, count := range filtered[provider] {
+			reasons = append(reasons, reason)
+			n += count
+		}
+		sort.Strings(reasons)
+		if bb.output == nil || bb.outputFormat != formatTable {
+			log.Info().
+				Str("provider", provider).
+				Int("filtered", n).
+				Strs("reasons", reasons).
+				Msg("records filtered out")
+			continue
+		}
+		fmt.Fprintf(bb.output, "\n%s records filtered out: %d\n", provider, n)
+		for _, reason := range reasons {
+			fmt.Fprintf(bb.output, "  %s: %d\n", reason, filtered[provider][reason])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterReason(t *testing.T) {
+	record := SourceRecord{Category: "Electricity", ParentCategory: "Utilities", Group: "Apartment", Friend: "Annie Edison"}
+	for _, tc := range []struct {
+		include, exclude RecordFilter
+		reason           string
+	}{
+		{RecordFilter{}, RecordFilter{}, ""},
+		{RecordFilter{Categories: []string{"utilities"}}, RecordFilter{}, ""},
+		{RecordFilter{Groups: []string{"Trip"}}, RecordFilter{}, "group 'Apartment' is not included"},
+		{RecordFilter{Friends: []string{"annie"}}, RecordFilter{Categories: []string{"Electricity"}}, "category 'Electricity' is excluded"},
+		{RecordFilter{}, RecordFilter{Friends: []string{"Annie Edison"}}, "friend 'Annie Edison' is excluded"},
+		{RecordFilter{}, RecordFilter{Friends: []string{"Edison"}}, ""},
+		{RecordFilter{}, RecordFilter{Categories: []string{"utilities"}}, "category 'Utilities' is excluded"},
+	} {
+		provider := NamedProvider{Include: tc.include, Exclude: tc.exclude}
+		require.Equal(t, tc.reason, provider.filterReason(record), "%+v %+v", tc.include, tc.exclude)
+	}
+
+	provider := NamedProvider{Include: RecordFilter{Groups: []string{"Apartment"}}}
+	require.Equal(t, "without a group", provider.filterReason(SourceRecord{}))
+}
+
+func TestImportAllFilters(t *testing.T) {
+	r := require.New(t)
+
+	var out bytes.Buffer
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: &fakeYNAB{},
+		providers: []NamedProvider{{
+			Name:      "splitwise",
+			AccountID: "account",
+			Include:   RecordFilter{Friends: []string{"Annie"}},
+			Exclude:   RecordFilter{Categories: []string{"Utilities"}},
+			TransactionProvider: &SplitwiseTransactionProvider{
+				userID: 456,
+				client: &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+			},
+		}},
+		Since:        date(2020, 1, 1),
+		dryRun:       true,
+		output:       &out,
+		outputFormat: formatTable,
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+	r.Contains(out.String(), "2 to create, 0 duplicate, 0 updated at source, 0 conflicting, 0 dropped, 1 filtered\n")
+	r.Contains(out.String(), "\nsplitwise records filtered out: 1\n  category 'Utilities' is excluded: 1\n")
+}
+
+func TestImportAllFiltersBeforeConverting(t *testing.T) {
+	r := require.New(t)
+
+	var out bytes.Buffer
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: &fakeYNAB{},
+		providers: []NamedProvider{{
+			Name:      "splitwise",
+			AccountID: "account",
+			Exclude:   RecordFilter{Groups: []string{"Apartment"}},
+			TransactionProvider: &SplitwiseTransactionProvider{
+				userID: 456,
+				client: &mockClient{expensesResponse: "fixtures/mock_expenses_multi_user.json"},
+			},
+		}},
+		Since:        date(2020, 1, 1),
+		dryRun:       true,
+		output:       &out,
+		outputFormat: formatTable,
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+	r.Contains(out.String(), "2 to create, 0 duplicate, 0 updated at source, 0 conflicting, 0 dropped, 1 filtered\n",
+		"the expense shared with several users is excluded by its group rather than failing the provider")
+	r.Contains(out.String(), "\nsplitwise records filtered out: 1\n  group 'Apartment' is excluded: 1\n")
+}
@@ -5,9 +5,13 @@ go 1.13
 // dev dependencies
 
 require (
+	github.com/pelletier/go-toml v1.9.5
 	github.com/rs/zerolog v1.18.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9 // indirect
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"budgetbridge/ynab"
+)
+
+const journalFileName = "journal.log"
+
+// Journal is an append-only record of what each sync run created, so that a
+// run can be undone.
+type Journal struct {
+	path string
+}
+
+func newJournal(dir string) *Journal {
+	return &Journal{path: filepath.Join(dir, journalFileName)}
+}
+
+// A JournalRun records a single sync run.
+type JournalRun struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	BudgetID  string    `json:"budget_id"`
+	Providers []string  `json:"providers"`
+	// Created are the transactions created in YNAB during the run.
+	Created []JournalTransaction `json:"created"`
+	// Deleted are the IDs of the transactions deleted by an undo which
+	// hasn't finished yet.
+	Deleted []string `json:"deleted,omitempty"`
+	// Undone is when the transactions of the run were deleted, if they were.
+	Undone *time.Time `json:"undone,omitempty"`
+}
+
+// A JournalTransaction is a transaction created in YNAB and the source record
+// it was created from.
+type JournalTransaction struct {
+	TransactionID string       `json:"transaction_id"`
+	AccountID     string       `json:"account_id"`
+	ImportID      string       `json:"import_id,omitempty"`
+	Source        SourceRecord `json:"source"`
+	// Rules are the rules which fired for the transaction.
+	Rules []string `json:"rules,omitempty"`
+}
+
+// journalRecord is a single line of the journal file: either a run, the
+// deletion of one of its transactions while undoing it, or the undoing of an
+// earlier run.
+type journalRecord struct {
+	Run    *JournalRun `json:"run,omitempty"`
+	Undo   string      `json:"undo,omitempty"`
+	UndoAt *time.Time  `json:"undo_at,omitempty"`
+	// Deleted is the ID of a transaction of the run being undone which was
+	// deleted.
+	Deleted string `json:"deleted,omitempty"`
+}
+
+// newRunID returns an ID which sorts by the time of the run.
+func newRunID(now time.Time) string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return now.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// Record appends a run to the journal.
+func (j *Journal) Record(run JournalRun) error {
+	return j.append(journalRecord{Run: &run})
+}
+
+// MarkUndone records that the transactions of a run were deleted.
+func (j *Journal) MarkUndone(runID string, at time.Time) error {
+	return j.append(journalRecord{Undo: runID, UndoAt: &at})
+}
+
+// MarkDeleted records that a transaction of a run was deleted while undoing
+// it, so that it isn't deleted again if the undo is retried.
+func (j *Journal) MarkDeleted(runID, transactionID string) error {
+	return j.append(journalRecord{Undo: runID, Deleted: transactionID})
+}
+
+func (j *Journal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Runs returns every recorded run, oldest first.
+func (j *Journal) Runs() ([]JournalRun, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []JournalRun
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", j.path, n, err)
+		}
+		switch {
+		case record.Run != nil:
+			index[record.Run.ID] = len(runs)
+			runs = append(runs, *record.Run)
+		case record.Undo != "" && record.Deleted != "":
+			if i, ok := index[record.Undo]; ok {
+				runs[i].Deleted = append(runs[i].Deleted, record.Deleted)
+			}
+		case record.Undo != "":
+			if i, ok := index[record.Undo]; ok {
+				runs[i].Undone = record.UndoAt
+			}
+		}
+	}
+	return runs, scanner.Err()
+}
+
+// Run returns the run with the given ID, or the most recent run which hasn't
+// been undone if the ID is empty.
+func (j *Journal) Run(id string) (JournalRun, error) {
+	runs, err := j.Runs()
+	if err != nil {
+		return JournalRun{}, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.ID == id || (id == "" && run.Undone == nil && len(run.Created) > 0) {
+			return run, nil
+		}
+	}
+	if id == "" {
+		return JournalRun{}, fmt.Errorf("there are no runs to undo")
+	}
+	return JournalRun{}, fmt.Errorf("no run with ID '%s'", id)
+}
+
+// transactionDeleter is the part of the YNAB API needed to undo a run.
+type transactionDeleter interface {
+	DeleteTransaction(ctx context.Context, budgetID, transactionID string) (ynab.TransactionResponse, error)
+}
+
+func runUndo(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	yes := fs.Bool("yes", false, "delete the transactions without asking for confirmation")
+	args = parseArgs(fs, args)
+	if len(args) > 1 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx = withVault(ctx, config.vault)
+	journal := newJournal(config.dataDir())
+	run, err := journal.Run(firstArg(args))
+	if err != nil {
+		return err
+	}
+	switch {
+	case run.Undone != nil:
+		return fmt.Errorf("run %s was already undone at %s", run.ID, run.Undone.Format(time.RFC3339))
+	case len(run.Created) == 0:
+		return fmt.Errorf("run %s did not create any transactions", run.ID)
+	}
+	if !*yes {
+		p := newPrompter(os.Stdin, os.Stdout)
+		question := fmt.Sprintf("Delete the %d transaction(s) created by run %s at %s?",
+			len(run.Created), run.ID, run.Time.Format(time.RFC3339))
+		if ok, err := p.confirm(question, false); err != nil || !ok {
+			return err
+		}
+	}
+	client, err := newYNABClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	return undoRun(ctx, client, journal, run, os.Stdout)
+}
+
+// undoRun deletes the transactions created by a run. Each deletion is
+// recorded, and the run is only marked as undone once all of them are
+// deleted, so that a partial undo can be retried without deleting any twice.
+func undoRun(ctx context.Context, client transactionDeleter, journal *Journal, run JournalRun, out io.Writer) error {
+	deleted := make(map[string]bool, len(run.Deleted))
+	for _, id := range run.Deleted {
+		deleted[id] = true
+	}
+	var failed int
+	for _, t := range run.Created {
+		if deleted[t.TransactionID] {
+			continue
+		}
+		if _, err := client.DeleteTransaction(ctx, run.BudgetID, t.TransactionID); err != nil {
+			fmt.Fprintf(out, "could not delete transaction %s (%s %s): %s\n",
+				t.TransactionID, t.Source.Provider, t.Source.ID, err)
+			failed++
+			continue
+		}
+		if err := journal.MarkDeleted(run.ID, t.TransactionID); err != nil {
+			return fmt.Errorf("record deletion of transaction %s: %s", t.TransactionID, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transaction(s) could not be deleted", failed, len(run.Created))
+	}
+	if err := journal.MarkUndone(run.ID, time.Now()); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "deleted %d transaction(s) created by run %s\n", len(run.Created), run.ID)
+	return nil
+}
+
+func runHistory(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	runs, err := newJournal(config.dataDir()).Runs()
+	if err != nil {
+		return err
+	}
+	return writeHistory(os.Stdout, *format, runs)
+}
+
+func writeHistory(w io.Writer, format string, runs []JournalRun) error {
+	t := newTable("RUN", "TIME", "PROVIDERS", "CREATED", "UNDONE")
+	for _, run := range runs {
+		undone := "-"
+		if run.Undone != nil {
+			undone = run.Undone.Format(time.RFC3339)
+		}
+		t.add(run.ID, run.Time.Format(time.RFC3339), strings.Join(run.Providers, ","), len(run.Created), undone)
+	}
+	return writeOutput(w, format, t, runs)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalRuns(t *testing.T) {
+	r := require.New(t)
+
+	journal := newJournal(t.TempDir())
+	_, err := journal.Run("")
+	r.EqualError(err, "there are no runs to undo")
+
+	first := JournalRun{ID: "1", BudgetID: "budget", Created: []JournalTransaction{{TransactionID: "a"}}}
+	second := JournalRun{ID: "2", BudgetID: "budget", Created: []JournalTransaction{{TransactionID: "b"}}}
+	empty := JournalRun{ID: "3", BudgetID: "budget"}
+	r.NoError(journal.Record(first))
+	r.NoError(journal.Record(second))
+	r.NoError(journal.Record(empty))
+
+	run, err := journal.Run("")
+	r.NoError(err)
+	r.Equal("2", run.ID, "runs which created nothing are skipped")
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.NoError(journal.MarkUndone("2", at))
+	run, err = journal.Run("")
+	r.NoError(err)
+	r.Equal("1", run.ID)
+
+	run, err = journal.Run("2")
+	r.NoError(err)
+	r.NotNil(run.Undone)
+	r.True(at.Equal(*run.Undone))
+
+	_, err = journal.Run("4")
+	r.EqualError(err, "no run with ID '4'")
+
+	runs, err := journal.Runs()
+	r.NoError(err)
+	r.Len(runs, 3)
+}
+
+// importIDProvider returns a transaction with an import ID, like a real
+// provider.
+type importIDProvider struct{}
+
+func (importIDProvider) Transactions(context.Context, YnabInfo) ([]SourceTransaction, error) {
+	importID := "splitwise:1"
+	return []SourceTransaction{{
+		Transaction: ynab.Transaction{Id: "tx", ImportId: &importID},
+		Source:      SourceRecord{ID: "1"},
+	}}, nil
+}
+
+func TestImportAllJournal(t *testing.T) {
+	r := require.New(t)
+
+	journal := newJournal(t.TempDir())
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: &fakeYNAB{},
+		providers: []NamedProvider{
+			{Name: "fake", AccountID: "account", TransactionProvider: importIDProvider{}},
+		},
+		journal: journal,
+	}
+	r.NoError(bb.ImportAll(context.Background()))
+
+	run, err := journal.Run("")
+	r.NoError(err)
+	r.Equal("budget", run.BudgetID)
+	r.Equal([]string{"fake"}, run.Providers)
+	r.Equal([]JournalTransaction{{
+		TransactionID: "tx",
+		AccountID:     "account",
+		ImportID:      "splitwise:1",
+		Source:        SourceRecord{Provider: "fake", ID: "1"},
+	}}, run.Created)
+
+	bb.dryRun = true
+	bb.output, bb.outputFormat = io.Discard, formatTable
+	r.NoError(bb.ImportAll(context.Background()))
+	runs, err := journal.Runs()
+	r.NoError(err)
+	r.Len(runs, 1, "dry runs are not recorded")
+}
+
+type fakeDeleter struct {
+	deleted []string
+	fail    map[string]bool
+}
+
+func (f *fakeDeleter) DeleteTransaction(_ context.Context, _, id string) (ynab.TransactionResponse, error) {
+	if f.fail[id] {
+		return ynab.TransactionResponse{}, errors.New("internal server error")
+	}
+	for _, d := range f.deleted {
+		if d == id {
+			return ynab.TransactionResponse{}, errors.New("not found")
+		}
+	}
+	f.deleted = append(f.deleted, id)
+	return ynab.TransactionResponse{}, nil
+}
+
+func TestUndoRun(t *testing.T) {
+	r := require.New(t)
+
+	journal := newJournal(t.TempDir())
+	run := JournalRun{ID: "1", BudgetID: "budget", Created: []JournalTransaction{
+		{TransactionID: "a"},
+		{TransactionID: "b"},
+	}}
+	r.NoError(journal.Record(run))
+
+	var out bytes.Buffer
+	client := &fakeDeleter{fail: map[string]bool{"b": true}}
+	r.EqualError(undoRun(context.Background(), client, journal, run, &out),
+		"1 of 2 transaction(s) could not be deleted")
+	r.Contains(out.String(), "could not delete transaction b")
+	run, err := journal.Run("1")
+	r.NoError(err)
+	r.Nil(run.Undone, "a partial undo is not recorded")
+	r.Equal([]string{"a"}, run.Deleted)
+
+	client.fail = nil
+	r.NoError(undoRun(context.Background(), client, journal, run, &out), "a is not deleted again")
+	r.Equal([]string{"a", "b"}, client.deleted)
+	run, err = journal.Run("1")
+	r.NoError(err)
+	r.NotNil(run.Undone)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"budgetbridge/splitwise"
+	"budgetbridge/ynab"
+)
+
+// loadYNAB loads the config and creates a YNAB client for a listing command.
+//
+// The cache is bypassed, since the point of listing is to see the current
+// state of the budget.
+func loadYNAB(ctx context.Context, configPath string) (context.Context, Config, *ynab.Client, error) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return ctx, config, nil, err
+	}
+	ctx = withVault(ctx, config.vault)
+	client, err := newYNABClient(ctx, config)
+	return ctx, config, client, err
+}
+
+// selectBudget returns the ID of the budget given by ID or name, or of the
+// configured budget if empty.
+func selectBudget(ctx context.Context, client budgetLister, config Config, budget string) (string, error) {
+	if budget == "" {
+		return getBudgetID(ctx, client, config)
+	}
+	res, err := client.Budgets(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetch budgets: %s", err)
+	}
+	if b, ok := findBudget(res.Budgets, budget); ok {
+		return b.Id, nil
+	}
+	b, err := findBudgetByName(res.Budgets, budget)
+	return b.Id, err
+}
+
+func runBudgets(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	ctx, _, client, err := loadYNAB(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	res, err := client.Budgets(ctx)
+	if err != nil {
+		return fmt.Errorf("fetch budgets: %s", err)
+	}
+	return writeBudgets(os.Stdout, *format, res)
+}
+
+func writeBudgets(w io.Writer, format string, res ynab.BudgetsResponse) error {
+	t := newTable("NAME", "CURRENCY", "LAST MODIFIED", "DEFAULT", "ID")
+	for _, b := range res.Budgets {
+		isDefault := res.DefaultBudget != nil && res.DefaultBudget.Id == b.Id
+		t.add(b.Name, b.CurrencyFormat.IsoCode, b.LastModifiedOn.Format("2006-01-02"), yesNo(isDefault), b.Id)
+	}
+	return writeOutput(w, format, t, res.Budgets)
+}
+
+func runAccounts(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	budget := fs.String("budget", "", "the ID or name of the budget (default: the configured budget)")
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	ctx, config, client, err := loadYNAB(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	budgetID, err := selectBudget(ctx, client, config, *budget)
+	if err != nil {
+		return err
+	}
+	res, err := client.Accounts(ctx, budgetID)
+	if err != nil {
+		return fmt.Errorf("fetch accounts: %s", err)
+	}
+	return writeAccounts(os.Stdout, *format, res.Accounts)
+}
+
+func writeAccounts(w io.Writer, format string, accounts []ynab.Account) error {
+	t := newTable("NAME", "TYPE", "ON BUDGET", "CLOSED", "BALANCE", "ID")
+	var listed []ynab.Account
+	for _, a := range accounts {
+		if a.Deleted {
+			continue
+		}
+		listed = append(listed, a)
+		t.add(a.Name, a.Type, yesNo(a.OnBudget), yesNo(a.Closed), formatMilliUnits(a.Balance), a.Id)
+	}
+	return writeOutput(w, format, t, listed)
+}
+
+func runCategories(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	budget := fs.String("budget", "", "the ID or name of the budget (default: the configured budget)")
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	ctx, config, client, err := loadYNAB(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	budgetID, err := selectBudget(ctx, client, config, *budget)
+	if err != nil {
+		return err
+	}
+	res, err := client.Categories(ctx, ynab.CategoriesRequest{BudgetID: budgetID})
+	if err != nil {
+		return fmt.Errorf("fetch categories: %s", err)
+	}
+	return writeCategories(os.Stdout, *format, res.CategoryGroups)
+}
+
+func writeCategories(w io.Writer, format string, groups []ynab.CategoryGroup) error {
+	t := newTable("GROUP", "CATEGORY", "HIDDEN", "ID")
+	var listed []ynab.CategoryGroup
+	for _, g := range groups {
+		if g.Deleted {
+			continue
+		}
+		group := g
+		group.Categories = nil
+		for _, c := range g.Categories {
+			if c.Deleted {
+				continue
+			}
+			group.Categories = append(group.Categories, c)
+			t.add(g.Name, c.Name, yesNo(g.Hidden || c.Hidden), c.Id)
+		}
+		listed = append(listed, group)
+	}
+	return writeOutput(w, format, t, listed)
+}
+
+func runSplitwise(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	format := formatFlag(fs)
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one thing to list")
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx = withVault(ctx, config.vault)
+	options, ok := config.Providers.Map["splitwise"].Options.(*SplitwiseOptions)
+	if !ok {
+		return fmt.Errorf("splitwise is not configured")
+	}
+	client, err := options.newSplitwiseClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "categories":
+		res, err := client.GetCategories(ctx)
+		if err != nil {
+			return fmt.Errorf("get_categories: %s", err)
+		}
+		return writeSplitwiseCategories(os.Stdout, *format, res.Categories)
+	case "friends":
+		friends, err := client.GetFriends(ctx)
+		if err != nil {
+			return fmt.Errorf("get_friends: %s", err)
+		}
+		return writeSplitwiseFriends(os.Stdout, *format, friends)
+	case "groups":
+		groups, err := client.GetGroups(ctx)
+		if err != nil {
+			return fmt.Errorf("get_groups: %s", err)
+		}
+		return writeSplitwiseGroups(os.Stdout, *format, groups)
+	}
+	fs.Usage()
+	return fmt.Errorf("cannot list '%s'", args[0])
+}
+
+func writeSplitwiseCategories(w io.Writer, format string, categories []splitwise.Category) error {
+	t := newTable("PARENT", "CATEGORY", "ID")
+	for _, c := range categories {
+		for _, sub := range c.Subcategories {
+			t.add(c.Name, sub.Name, sub.ID)
+		}
+	}
+	return writeOutput(w, format, t, categories)
+}
+
+func writeSplitwiseFriends(w io.Writer, format string, friends []splitwise.Friend) error {
+	t := newTable("NAME", "BALANCE", "ID")
+	for _, f := range friends {
+		t.add(strings.TrimSpace(f.FirstName+" "+f.LastName), formatBalances(f.Balance), f.ID)
+	}
+	return writeOutput(w, format, t, friends)
+}
+
+func writeSplitwiseGroups(w io.Writer, format string, groups []splitwise.Group) error {
+	t := newTable("NAME", "TYPE", "MEMBERS", "ID")
+	for _, g := range groups {
+		t.add(g.Name, g.GroupType, len(g.Members), g.ID)
+	}
+	return writeOutput(w, format, t, groups)
+}
+
+func formatBalances(balances []splitwise.Balance) string {
+	if len(balances) == 0 {
+		return "settled"
+	}
+	parts := make([]string, len(balances))
+	for i, b := range balances {
+		parts[i] = b.Amount + " " + b.CurrencyCode
+	}
+	return strings.Join(parts, ", ")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAccounts(t *testing.T) {
+	r := require.New(t)
+
+	accounts := []ynab.Account{
+		{Id: "checking", Name: "Checking", Type: "checking", OnBudget: true, Balance: 1234560},
+		{Id: "splitwise", Name: "Splitwise", Type: "otherAsset", Balance: -5050},
+		{Id: "gone", Name: "Gone", Deleted: true},
+	}
+
+	var out bytes.Buffer
+	r.NoError(writeAccounts(&out, formatTable, accounts))
+	r.Equal(`NAME       TYPE        ON BUDGET  CLOSED  BALANCE  ID
+Checking   checking    yes        no      1234.56  checking
+Splitwise  otherAsset  no         no      -5.05    splitwise
+`, out.String())
+
+	out.Reset()
+	r.NoError(writeAccounts(&out, formatJSON, accounts))
+	var listed []ynab.Account
+	r.NoError(json.Unmarshal(out.Bytes(), &listed))
+	r.Equal(accounts[:2], listed)
+
+	r.EqualError(writeAccounts(&out, "xml", accounts), "unknown output format 'xml'")
+}
+
+func TestWriteCategories(t *testing.T) {
+	r := require.New(t)
+
+	groups := []ynab.CategoryGroup{
+		{Id: "everyday", Name: "Everyday", Categories: []ynab.Category{
+			{Id: "groceries", Name: "Groceries"},
+			{Id: "old", Name: "Old", Hidden: true},
+			{Id: "gone", Name: "Gone", Deleted: true},
+		}},
+		{Id: "deleted", Name: "Deleted", Deleted: true, Categories: []ynab.Category{
+			{Id: "other", Name: "Other"},
+		}},
+	}
+
+	var out bytes.Buffer
+	r.NoError(writeCategories(&out, formatTable, groups))
+	r.Equal(`GROUP     CATEGORY   HIDDEN  ID
+Everyday  Groceries  no      groceries
+Everyday  Old        yes     old
+`, out.String())
+}
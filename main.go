@@ -3,28 +3,30 @@ package main
 import (
 	"bufio"
 	"context"
-	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path"
 	"runtime/debug"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"golang.org/x/oauth2"
-
 	"budgetbridge/ynab"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-const (
-	ynabCacheName = "ynab_cache.json"
-)
+// budgetLister is the part of the YNAB API needed to select a budget.
+type budgetLister interface {
+	Budgets(context.Context) (ynab.BudgetsResponse, error)
+}
 
-func getBudgetID(ctx context.Context, ynabClient ynabClient, config Config) (string, error) {
-	if config.BudgetID != nil {
+func getBudgetID(ctx context.Context, ynabClient budgetLister, config Config) (string, error) {
+	switch {
+	case config.BudgetID != nil && config.Budget != "":
+		return "", fmt.Errorf("only one of budget_id or budget may be configured")
+	case config.BudgetID != nil:
 		log.Debug().Msg("using pre-configured budget_id")
 		return *config.BudgetID, nil
 	}
@@ -33,6 +35,10 @@ func getBudgetID(ctx context.Context, ynabClient ynabClient, config Config) (str
 	if err != nil {
 		return "", fmt.Errorf("fetch budget_id: %s", err)
 	}
+	if config.Budget != "" {
+		budget, err := findBudgetByName(res.Budgets, config.Budget)
+		return budget.Id, err
+	}
 	if len(res.Budgets) == 1 {
 		return res.Budgets[0].Id, nil
 	}
@@ -42,11 +48,131 @@ func getBudgetID(ctx context.Context, ynabClient ynabClient, config Config) (str
 	return "", fmt.Errorf("no default budget available")
 }
 
-func newYNABClient(ctx context.Context, accessToken string) *ynab.Client {
-	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: accessToken,
-	}))
-	return ynab.NewClient(httpClient)
+// openCachingClient creates a YNAB client which caches through the configured
+// cache. The cache must be closed with closeCache once done with.
+func openCachingClient(ctx context.Context, config Config) (*CachingClient, error) {
+	ynabCache, err := newYNABCache(config.Cache)
+	if err != nil {
+		return nil, err
+	}
+	if err := ynabCache.Open(); err != nil {
+		return nil, err
+	}
+	client, err := newYNABClient(ctx, config)
+	if err != nil {
+		closeCache(ynabCache)
+		return nil, err
+	}
+	if config.Cache.CreateMissingDir {
+		if err := os.MkdirAll(config.Cache.Dir, os.ModePerm); err != nil {
+			closeCache(ynabCache)
+			return nil, err
+		}
+	}
+	return &CachingClient{
+		client: client,
+		cache:  ynabCache,
+		config: config.Cache,
+	}, nil
+}
+
+// newBudgetBridge resolves the budget, accounts and categories of the config
+// and initializes its providers.
+func newBudgetBridge(ctx context.Context, config Config, ynabClient *CachingClient) (BudgetBridge, error) {
+	budgetID, err := getBudgetID(ctx, ynabClient, config)
+	if err != nil {
+		return BudgetBridge{}, err
+	}
+
+	if config.Providers.hasAccountNames() || config.Rules.hasAccountNames() {
+		accounts, err := loadAccounts(ctx, ynabClient, budgetID, config.accountNames())
+		if err != nil {
+			return BudgetBridge{}, err
+		}
+		if err := config.Providers.resolveAccounts(accounts); err != nil {
+			return BudgetBridge{}, err
+		}
+		if err := config.Rules.resolveAccounts(accounts); err != nil {
+			return BudgetBridge{}, err
+		}
+	}
+	categories, err := loadCategories(ctx, ynabClient, budgetID, config.categoryRefs())
+	if err != nil {
+		return BudgetBridge{}, err
+	}
+	if err := config.Providers.resolveCategories(categories); err != nil {
+		return BudgetBridge{}, err
+	}
+	if err := config.Rules.resolveCategories(categories.resolver()); err != nil {
+		return BudgetBridge{}, err
+	}
+
+	state, err := loadState(config.dataDir())
+	if err != nil {
+		return BudgetBridge{}, fmt.Errorf("load state: %s", err)
+	}
+
+	return BudgetBridge{
+		BudgetID:     budgetID,
+		LookBackDays: config.LookBackDays,
+		ynabClient:   ynabClient,
+		providers:    config.Providers.initAll(ctx),
+		categories:   categories,
+		rules:        config.Rules,
+		suggestions:  config.Suggestions,
+		WindowDays:   config.BackfillWindowDays,
+		state:        state,
+		journal:      newJournal(config.dataDir()),
+	}, nil
+}
+
+// loadAccounts fetches the accounts of a budget.
+//
+// If any of the given account names, from providers or rules, are not among
+// the cached accounts then the cache is assumed to be stale, and the accounts
+// are fetched again.
+func loadAccounts(ctx context.Context, client *CachingClient, budgetID string, names []string) ([]ynab.Account, error) {
+	res, err := client.Accounts(ctx, budgetID)
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingAccounts(names, res.Accounts); len(missing) > 0 {
+		log.Info().
+			Strs("missing", missing).
+			Msg("config refers to unknown accounts, refreshing cache")
+		if err := client.InvalidateAccounts(budgetID); err != nil {
+			return nil, err
+		}
+		res, err = client.Accounts(ctx, budgetID)
+		return res.Accounts, err
+	}
+	return res.Accounts, nil
+}
+
+// loadCategories fetches the categories of a budget and indexes them.
+//
+// If any of the given references are not among the cached categories then the
+// cache is assumed to be stale, and the categories are fetched again.
+func loadCategories(ctx context.Context, client *CachingClient, budgetID string, refs []categoryRef) (*CategoryIndex, error) {
+	req := ynab.CategoriesRequest{BudgetID: budgetID}
+	res, err := client.Categories(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	categories := newCategoryIndex(res.CategoryGroups)
+	if missing := missingCategoryRefs(refs, categories); len(missing) > 0 {
+		log.Info().
+			Strs("missing", missing).
+			Msg("category mapping refers to unknown categories, refreshing cache")
+		if err := client.InvalidateCategories(budgetID); err != nil {
+			return nil, err
+		}
+		if res, err = client.Categories(ctx, req); err != nil {
+			return nil, err
+		}
+		categories = newCategoryIndex(res.CategoryGroups)
+	}
+	return categories, nil
 }
 
 func initLogging() func() error {
@@ -98,91 +224,126 @@ func (f *dateFlag) String() string {
 }
 
 func main() {
-	configPath := flag.String("config", "config.json", "the path of your config.json file")
-	dryRun := flag.Bool("dry", false, "emit the transactions but do not create them.")
-
-	lastUpdateHint := dateFlag{
-		layout: "2006-01-02",
-	}
-	flag.Var(&lastUpdateHint, "since", "how far to look back for transactions.")
+	os.Exit(run())
+}
 
-	flag.Parse()
+func run() (exitCode int) {
 	flush := initLogging()
 	defer flush()
-	defer defaultPanicHandler()
-
-	var config Config
-	err := config.Providers.SetRegistry(map[string]NewProvider{
-		"splitwise": &SplitwiseOptions{},
-	})
-	check(err)
-
-	err = config.load(*configPath)
-	check(err)
+	defer defaultPanicHandler(&exitCode)
 
 	ctx := context.Background()
+	args := os.Args[1:]
+	var err error
+	switch name := firstArg(args); {
+	case name == "" || strings.HasPrefix(name, "-"):
+		// Running without a command predates subcommands, so it's kept as
+		// an alias of sync for existing scheduled jobs.
+		if name == "-h" || name == "-help" || name == "--help" {
+			usage(os.Stderr)
+			return 2
+		}
+		log.Warn().Msg("running without a command is deprecated, use `budgetbridge sync`")
+		cmd := findCommand("sync")
+		err = cmd.run(ctx, cmd, args)
+	case name == "help":
+		usage(os.Stdout)
+	default:
+		cmd := findCommand(name)
+		if cmd == nil {
+			usage(os.Stderr)
+			err = fmt.Errorf("unknown command '%s'", name)
+			break
+		}
+		err = cmd.run(ctx, cmd, args[1:])
+	}
+	if err != nil {
+		log.Err(err).Msg("exiting due to error")
+		return 1
+	}
+	return 0
+}
 
-	ynabCache := &FileCache{
-		path:          path.Join(config.Cache.Dir, ynabCacheName),
-		createMissing: config.Cache.CreateMissingDir,
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
 	}
-	check(ynabCache.Open())
+	return args[0]
+}
 
-	ynabClient := &CachingClient{
-		client: newYNABClient(ctx, config.AccessToken),
-		cache:  ynabCache,
+// usage describes every command.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: budgetbridge <command> [flags]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.usage, cmd.description)
 	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun 'budgetbridge <command> -h' for the flags of a command.\n")
+}
 
-	if config.Cache.CreateMissingDir {
-		err = os.MkdirAll(config.Cache.Dir, os.ModePerm)
-		check(err)
+func runSync(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	dryRun := fs.Bool("dry", false, "show what would be imported without creating anything.")
+	format := formatFlag(fs)
+	interactive := fs.Bool("interactive", false, "review each transaction before it's created.")
+
+	since := dateFlag{layout: "2006-01-02"}
+	until := dateFlag{layout: "2006-01-02"}
+	fs.Var(&since, "since", "backfill transactions dated on or after this date (YYYY-MM-DD).")
+	fs.Var(&until, "until", "only import transactions dated before this date (YYYY-MM-DD).")
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	if !since.time.IsZero() && !until.time.IsZero() && !since.time.Before(until.time) {
+		return fmt.Errorf("-since must be before -until")
+	}
+	if *interactive && !isTerminal(os.Stdin) {
+		return fmt.Errorf("-interactive requires a terminal")
 	}
-	budgetID, err := getBudgetID(ctx, ynabClient, config)
-	check(err)
 
-	res, err := ynabClient.Categories(ctx, ynab.CategoriesRequest{BudgetID: budgetID})
-	check(err)
-	var categories []ynab.Category
-	for _, group := range res.CategoryGroups {
-		categories = append(categories, group.Categories...)
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
 	}
+	ctx = withVault(ctx, config.vault)
 
-	providers := config.Providers.initAll(ctx)
-	if len(providers) == 0 {
-		log.Warn().Msg("no providers are configured")
-		return
+	ynabClient, err := openCachingClient(ctx, config)
+	if err != nil {
+		return err
 	}
+	// Anything fetched is still worth keeping if the run fails or panics.
+	defer closeCache(ynabClient.cache)
 
-	bridge := BudgetBridge{
-		budgetID,
-		config.LookBackDays,
-		ynabClient,
-		providers,
-		categories,
-		*dryRun,
+	bridge, err := newBudgetBridge(ctx, config, ynabClient)
+	if err != nil {
+		return err
+	}
+	if len(bridge.providers) == 0 {
+		log.Warn().Msg("no providers are configured")
+		return nil
 	}
-	err = bridge.ImportAll(ctx, config)
-	check(err)
+	bridge.dryRun = *dryRun
+	bridge.Since, bridge.Until = since.time, until.time
+	bridge.output, bridge.outputFormat = os.Stdout, *format
+	bridge.interactive, bridge.input = *interactive, os.Stdin
+	return bridge.ImportAll(ctx)
 }
 
-func defaultPanicHandler() {
+func defaultPanicHandler(exitCode *int) {
 	if v := recover(); v != nil {
 		var event *zerolog.Event
 		if e, ok := v.(error); ok {
 			event = log.Err(e)
-		} else if e, ok := v.(string); ok {
-			event = log.Error().Str("error", e)
+		} else {
+			event = log.Error().Interface("error", v)
 		}
 		stack := strings.Split(string(debug.Stack()), "\n")
 		event.
 			Str("type", fmt.Sprintf("%T\n", v)).
 			Strs("stack", stack).
 			Msg("exiting due to panic")
-	}
-}
-
-func check(err error) {
-	if err != nil {
-		panic(err)
+		*exitCode = 2
 	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// formatFlag adds the -format flag used by commands which list things.
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", formatTable, "the output format: 'table' or 'json'")
+}
+
+// A table is tabular output with a header row.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *table {
+	return &table{header: header}
+}
+
+func (t *table) add(values ...interface{}) {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = fmt.Sprint(v)
+	}
+	t.rows = append(t.rows, row)
+}
+
+func (t *table) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeOutput writes either the table, or v encoded as JSON.
+func writeOutput(w io.Writer, format string, t *table, v interface{}) error {
+	switch format {
+	case formatTable:
+		return t.write(w)
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+}
+
+// formatMilliUnits formats an amount in YNAB's milliunits as a decimal.
+func formatMilliUnits(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/1000, amount%1000/10)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errAborted is returned when the user ends the input while being prompted.
+var errAborted = errors.New("aborted")
+
+// prompter asks the user questions on a terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && line == "" {
+		return "", errAborted
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask prompts for a line of text, returning def if the answer is empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askRequired prompts until a non-empty answer is given.
+func (p *prompter) askRequired(question string) (string, error) {
+	for {
+		answer, err := p.ask(question, "")
+		if err != nil || answer != "" {
+			return answer, err
+		}
+	}
+}
+
+// askSecret prompts for a value without echoing it when on a terminal.
+func (p *prompter) askSecret(question string) (string, error) {
+	if p.out == os.Stdout && isTerminal(os.Stdin) {
+		value, err := readPassword(question + ": ")
+		return strings.TrimSpace(string(value)), err
+	}
+	return p.askRequired(question)
+}
+
+// confirm asks a yes or no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// list prints numbered options for use with askIndex.
+func (p *prompter) list(options []string) {
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %3d) %s\n", i+1, option)
+	}
+}
+
+// choose lists numbered options and prompts for one of them, returning its
+// index. A negative def means there is no default.
+func (p *prompter) choose(question string, options []string, def int) (int, error) {
+	p.list(options)
+	return p.askIndex(question, len(options), def, false)
+}
+
+// askIndex prompts for the number of one of n listed options, returning its
+// index. A negative def means there is no default. If optional is set an empty
+// answer returns -1.
+func (p *prompter) askIndex(question string, n, def int, optional bool) (int, error) {
+	var defStr string
+	if def >= 0 {
+		defStr = strconv.Itoa(def + 1)
+	}
+	for {
+		answer, err := p.ask(question, defStr)
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" && optional {
+			return -1, nil
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= n {
+			return i - 1, nil
+		}
+		fmt.Fprintf(p.out, "enter a number between 1 and %d\n", n)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"budgetbridge/ynab"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -18,7 +20,13 @@ type NewProvider interface {
 
 type YnabInfo struct {
 	LastUpdateHint time.Time
-	Categories     []ynab.Category
+	// Since and Until bound the dates of the transactions to load when
+	// backfilling. Until is exclusive, and either may be zero if unbounded.
+	Since, Until time.Time
+	Categories   *CategoryIndex
+	// Filter returns why a record is left out by the include and exclude
+	// lists of the provider, or an empty string if it's kept. It may be nil.
+	Filter func(SourceRecord) string
 }
 
 // A TransactionProvider loads the latest transactions from its source given the current Context.
@@ -26,10 +34,94 @@ type YnabInfo struct {
 // The provider *may* use the LastUpdateHint within the context in order to constrain the time
 // range which it searches.
 //
-// If the current context contains a non-empty list of categories, the provider *must* omit all
-// transactions outside of those categories.
+// If Since or Until are set, the provider *must* use them instead to bound the dates of the
+// transactions it loads.
+//
+// The provider need not filter its records by category, group or friend: the include and
+// exclude lists configured for it are applied to the SourceRecord of every transaction it
+// returns. It *must* therefore fill in whichever of those fields its source has. It *should*
+// however skip the records which Filter leaves out before converting them, so that a record
+// which can't be converted doesn't fail the provider when it's filtered out anyway.
 type TransactionProvider interface {
-	Transactions(context.Context, YnabInfo) ([]ynab.Transaction, error)
+	Transactions(context.Context, YnabInfo) ([]SourceTransaction, error)
+}
+
+// An Explainer is a TransactionProvider which can describe how a single
+// record becomes a transaction.
+type Explainer interface {
+	// Explain loads the record with the given ID and converts it as
+	// Transactions would, adding each step taken to the explanation.
+	Explain(ctx context.Context, info YnabInfo, id string, ex *explanation) (SourceTransaction, error)
+}
+
+// An unmappedReporter is a TransactionProvider which keeps track of the
+// categories of its records which had no mapping to a YNAB category.
+type unmappedReporter interface {
+	// UnmappedCategories returns how many records there were in each
+	// category without a mapping.
+	UnmappedCategories() map[string]int
+	// BrokenMappings returns how many records there were for each mapping
+	// which refers to a category that can't be used.
+	BrokenMappings() map[string]int
+}
+
+// A SourceTransaction is a transaction to create in YNAB, along with the
+// record it was made from.
+type SourceTransaction struct {
+	ynab.Transaction
+	Source SourceRecord
+	// Trace lists the rules which fired for the transaction, in order.
+	Trace []string
+	// Suggestion is the category suggested for the transaction, if it had
+	// none or only a default.
+	Suggestion *Suggestion
+}
+
+// ynabTransaction returns the transaction to create in YNAB. Only the parts of
+// a split transaction have categories, so the category of a split goes to its
+// first subtransaction unless that has its own.
+func (t SourceTransaction) ynabTransaction() ynab.Transaction {
+	tx := t.Transaction
+	if len(tx.Subtransactions) == 0 || tx.CategoryId == nil {
+		return tx
+	}
+	tx.Subtransactions = append([]ynab.SubTransaction(nil), tx.Subtransactions...)
+	if tx.Subtransactions[0].CategoryId == nil {
+		tx.Subtransactions[0].CategoryId = tx.CategoryId
+	}
+	tx.CategoryId = nil
+	return tx
+}
+
+// SourceRecord describes a record loaded by a provider.
+type SourceRecord struct {
+	// Provider is the name of the provider the record was loaded by.
+	Provider string `json:"provider"`
+	// ID identifies the record within the provider.
+	ID string `json:"id"`
+	// Description is the description of the record, before any rules
+	// changed the memo.
+	Description string `json:"description,omitempty"`
+	// Group is the name of the group the record belongs to within the
+	// provider, if it has one.
+	Group string `json:"group,omitempty"`
+	// Category is the name of the category of the record within the
+	// provider, if it has one.
+	Category string `json:"category,omitempty"`
+	// ParentCategory is the name of the parent of Category, if the provider
+	// nests its categories.
+	ParentCategory string `json:"parent_category,omitempty"`
+	// CategoryKey identifies Category within the provider where its name
+	// alone may not, such as "#12" for the Splitwise category with ID 12.
+	CategoryKey string `json:"category_key,omitempty"`
+	// Friend is the full name of the person the record is shared with, if
+	// there is one.
+	Friend string `json:"friend,omitempty"`
+	// Unmapped is set when the provider had no mapping for the category, so
+	// that the category of the transaction, if any, is only a default.
+	Unmapped bool `json:"unmapped,omitempty"`
+	// Raw is the record as it was loaded.
+	Raw json.RawMessage `json:"raw,omitempty"`
 }
 
 type NamedProvider struct {
@@ -39,6 +131,10 @@ type NamedProvider struct {
 	//
 	// Any new transactions from this provider will be created under this account.
 	AccountID string
+	// Include and Exclude filter the records of this provider.
+	Include, Exclude RecordFilter
+	// Defaults are set on every transaction of this provider.
+	Defaults TransactionDefaults
 
 	// The inner provider.
 	TransactionProvider
@@ -49,9 +145,19 @@ type ProviderConfig struct {
 	//
 	// Any new transactions from this provider will be created under this account.
 	AccountID string `json:"account_id"`
+	// Account selects the YNAB account by name, as an alternative to AccountID.
+	Account string `json:"account"`
+	// Include and Exclude filter the records of the provider before they're
+	// imported. Only records matching every include list are kept, unless
+	// they match any exclude list.
+	Include RecordFilter `json:"include"`
+	Exclude RecordFilter `json:"exclude"`
+	// Defaults are set on every transaction of the provider, before any
+	// rules apply.
+	Defaults TransactionDefaults `json:"defaults"`
 
 	// The generic provider options.
-	Options NewProvider
+	Options NewProvider `json:"options"`
 }
 
 type Providers struct {
@@ -69,15 +175,122 @@ func (p Providers) initAll(ctx context.Context) []NamedProvider {
 			continue
 		}
 		withAccountID := NamedProvider{
-			providerName,
-			providerConfig.AccountID,
-			provider,
+			Name:                providerName,
+			AccountID:           providerConfig.AccountID,
+			Include:             providerConfig.Include,
+			Exclude:             providerConfig.Exclude,
+			Defaults:            providerConfig.Defaults,
+			TransactionProvider: provider,
 		}
 		providers = append(providers, withAccountID)
 	}
 	return providers
 }
 
+// names returns the names of all configured providers in sorted order.
+func (p Providers) names() []string {
+	names := make([]string, 0, len(p.Map))
+	for name := range p.Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// An optionsValidator is implemented by provider options which can be checked
+// for mistakes once decoded, before the provider is created.
+type optionsValidator interface {
+	validate() error
+}
+
+// A categoryReferrer is implemented by provider options which refer to YNAB categories.
+type categoryReferrer interface {
+	categoryRefs() []categoryRef
+}
+
+// categoryRef refers to a YNAB category by either its ID or its name.
+type categoryRef struct {
+	ID   string
+	Name string
+	// Source describes where the reference was made, for use in messages.
+	Source string
+}
+
+func (ref categoryRef) String() string {
+	if ref.ID != "" {
+		return ref.ID
+	}
+	return ref.Name
+}
+
+// categoryRefs returns every YNAB category referred to by the configured providers.
+func (p Providers) categoryRefs() []categoryRef {
+	var refs []categoryRef
+	for name, providerConfig := range p.Map {
+		cr, ok := providerConfig.Options.(categoryReferrer)
+		if !ok {
+			continue
+		}
+		for _, ref := range cr.categoryRefs() {
+			ref.Source = fmt.Sprintf("%s: %s", name, ref.Source)
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// accountNames returns the names of the accounts the providers refer to.
+func (p Providers) accountNames() []string {
+	var names []string
+	for _, name := range p.names() {
+		if account := p.Map[name].Account; account != "" {
+			names = append(names, account)
+		}
+	}
+	return names
+}
+
+// accountNames returns the name of every YNAB account referred to by the
+// config.
+func (config *Config) accountNames() []string {
+	return append(config.Providers.accountNames(), config.Rules.accountNames()...)
+}
+
+// missingAccounts returns the names which match no account.
+func missingAccounts(names []string, accounts []ynab.Account) []string {
+	var missing []string
+	for _, name := range names {
+		if _, err := findAccountByName(accounts, name); errors.Is(err, errNoMatch) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// categoryRefs returns every YNAB category referred to by the config.
+func (config *Config) categoryRefs() []categoryRef {
+	return append(config.Providers.categoryRefs(), config.Rules.categoryRefs()...)
+}
+
+// missingCategoryRefs returns the references which match no category of the
+// index. References by either ID or name may be to unusable categories, which
+// are reported when the references are resolved rather than refetched.
+func missingCategoryRefs(refs []categoryRef, categories *CategoryIndex) []string {
+	var missing []string
+	for _, ref := range refs {
+		if ref.ID != "" {
+			if !categories.known(ref.ID) {
+				missing = append(missing, ref.String())
+			}
+		} else if _, err := categories.ByName(ref.Name); errors.Is(err, errNoMatch) {
+			if _, ok := categories.unusable(ref.Name); !ok {
+				missing = append(missing, ref.String())
+			}
+		}
+	}
+	return missing
+}
+
 func (pm *Providers) SetRegistry(registry map[string]NewProvider) error {
 	for k, v := range registry {
 		if err := pm.Register(k, v); err != nil {
@@ -110,7 +323,7 @@ func (pm *Providers) UnmarshalJSON(bytes []byte) error {
 
 		rt, ok := pm.registry[k]
 		if !ok {
-			return fmt.Errorf("unknown provider '%s'", k)
+			return &configError{Key: k, Err: fmt.Errorf("unknown provider")}
 		}
 
 		p := reflect.New(rt).Elem()
@@ -124,7 +337,15 @@ func (pm *Providers) UnmarshalJSON(bytes []byte) error {
 		providerConfig.Options = p.Interface().(NewProvider)
 
 		if err := json.Unmarshal(v, &providerConfig); err != nil {
-			return err
+			return keyError(k, err)
+		}
+		if err := providerConfig.Defaults.validate(); err != nil {
+			return keyError(k, keyError("defaults", err))
+		}
+		if v, ok := providerConfig.Options.(optionsValidator); ok {
+			if err := v.validate(); err != nil {
+				return keyError(k, keyError("options", err))
+			}
 		}
 		pm.Map[k] = providerConfig
 	}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"budgetbridge/ynab"
+)
+
+var (
+	errNoMatch   = errors.New("does not exist")
+	errAmbiguous = errors.New("is ambiguous")
+)
+
+// qualifiedNameSep separates the group from the name in a qualified category
+// name such as "Everyday: Groceries".
+const qualifiedNameSep = ": "
+
+// findBudgetByName returns the budget with the given name.
+func findBudgetByName(budgets []ynab.BudgetSummary, name string) (ynab.BudgetSummary, error) {
+	var matches []ynab.BudgetSummary
+	var names []string
+	for _, b := range budgets {
+		if b.Name == name {
+			matches = append(matches, b)
+		}
+		names = append(names, b.Name)
+	}
+	switch len(matches) {
+	case 0:
+		return ynab.BudgetSummary{}, &lookupError{"budget", name, errNoMatch, closestMatches(name, names)}
+	case 1:
+		return matches[0], nil
+	default:
+		return ynab.BudgetSummary{}, &lookupError{"budget", name, errAmbiguous, nil}
+	}
+}
+
+// findAccountByName returns the account with the given name, ignoring any
+// deleted accounts.
+func findAccountByName(accounts []ynab.Account, name string) (ynab.Account, error) {
+	var matches []ynab.Account
+	var names []string
+	for _, a := range accounts {
+		if a.Deleted {
+			continue
+		}
+		if a.Name == name {
+			matches = append(matches, a)
+		}
+		names = append(names, a.Name)
+	}
+	switch len(matches) {
+	case 0:
+		return ynab.Account{}, &lookupError{"account", name, errNoMatch, closestMatches(name, names)}
+	case 1:
+		return matches[0], nil
+	default:
+		return ynab.Account{}, &lookupError{"account", name, errAmbiguous, nil}
+	}
+}
+
+// lookupError is returned when a name does not identify exactly one entity.
+type lookupError struct {
+	Kind string
+	Name string
+	// Err is either errNoMatch or errAmbiguous.
+	Err error
+	// Suggestions are close matches when nothing matched exactly.
+	Suggestions []string
+}
+
+func (le *lookupError) Error() string {
+	msg := fmt.Sprintf("%s '%s' %s", le.Kind, le.Name, le.Err)
+	switch {
+	case errors.Is(le.Err, errAmbiguous):
+		msg += ", qualify it or use its ID instead"
+	case len(le.Suggestions) > 0:
+		msg += fmt.Sprintf(", did you mean %s?", strings.Join(quoteAll(le.Suggestions), " or "))
+	}
+	return msg
+}
+
+func (le *lookupError) Unwrap() error {
+	return le.Err
+}
+
+// hasAccountNames reports whether any provider selects its account by name.
+func (p Providers) hasAccountNames() bool {
+	for _, providerConfig := range p.Map {
+		if providerConfig.Account != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAccounts sets the account ID of every provider which selects its
+// account by name.
+func (p Providers) resolveAccounts(accounts []ynab.Account) error {
+	for _, name := range p.names() {
+		providerConfig := p.Map[name]
+		switch {
+		case providerConfig.Account == "":
+			continue
+		case providerConfig.AccountID != "":
+			return fmt.Errorf("provider '%s': only one of account_id or account may be configured", name)
+		}
+		account, err := findAccountByName(accounts, providerConfig.Account)
+		if err != nil {
+			return fmt.Errorf("provider '%s': %s", name, err)
+		}
+		providerConfig.AccountID = account.Id
+		p.Map[name] = providerConfig
+	}
+	return nil
+}
+
+// A categoryResolver is implemented by provider options which can replace
+// their references to YNAB category names with IDs.
+type categoryResolver interface {
+	resolveCategories(resolve func(name string) (string, error)) error
+}
+
+// resolveCategories replaces category names in the provider options with the
+// IDs of the categories they refer to.
+func (p Providers) resolveCategories(categories *CategoryIndex) error {
+	resolve := categories.resolver()
+	for _, name := range p.names() {
+		cr, ok := p.Map[name].Options.(categoryResolver)
+		if !ok {
+			continue
+		}
+		if err := cr.resolveCategories(resolve); err != nil {
+			return fmt.Errorf("provider '%s': %s", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategoryIndexByName(t *testing.T) {
+	r := require.New(t)
+
+	groups := []ynab.CategoryGroup{
+		{Name: "Everyday", Categories: []ynab.Category{
+			{Id: "groceries", Name: "Groceries"},
+			{Id: "misc-1", Name: "Misc"},
+			{Id: "gone", Name: "Gone", Deleted: true},
+		}},
+		{Name: "Bills", Categories: []ynab.Category{
+			{Id: "misc-2", Name: "Misc"},
+		}},
+	}
+	index := newCategoryIndex(groups)
+
+	c, err := index.ByName("Groceries")
+	r.NoError(err)
+	r.Equal("groceries", c.Id)
+
+	c, err = index.ByName("Bills: Misc")
+	r.NoError(err)
+	r.Equal("misc-2", c.Id)
+
+	_, err = index.ByName("Misc")
+	r.True(errors.Is(err, errAmbiguous))
+	r.EqualError(err, "category 'Misc' is ambiguous, qualify it or use its ID instead")
+
+	_, err = index.ByName("Gone")
+	r.True(errors.Is(err, errNoMatch))
+
+	_, err = index.ByName("Everyday: Grocries")
+	r.EqualError(err, "category 'Everyday: Grocries' does not exist, did you mean 'Everyday: Groceries'?")
+}
+
+func TestProvidersResolve(t *testing.T) {
+	r := require.New(t)
+
+	mapping := make(CategoryMapping)
+	mapping.Add(CategoryMappingEntry{Name: "Groceries", YnabName: "Everyday: Groceries"})
+	mapping.Add(CategoryMappingEntry{Name: "Rent", YnabId: "rent"})
+	providers := Providers{
+		Map: map[string]ProviderConfig{
+			"splitwise": {
+				Account: "Splitwise",
+				Options: &SplitwiseOptions{CategoryMapping: mapping},
+			},
+		},
+	}
+	r.True(providers.hasAccountNames())
+
+	err := providers.resolveAccounts([]ynab.Account{
+		{Id: "old", Name: "Splitwise", Deleted: true},
+		{Id: "splitwise", Name: "Splitwise"},
+	})
+	r.NoError(err)
+	r.Equal("splitwise", providers.Map["splitwise"].AccountID)
+
+	err = providers.resolveCategories(newCategoryIndex([]ynab.CategoryGroup{
+		{Name: "Everyday", Categories: []ynab.Category{
+			{Id: "groceries", Name: "Groceries"},
+		}},
+	}))
+	r.NoError(err)
+	r.Equal("groceries", mapping["Groceries"].YnabId)
+	r.Equal("rent", mapping["Rent"].YnabId)
+
+	err = providers.resolveCategories(nil)
+	r.NoError(err, "entries are only resolved once")
+
+	providers.Map["splitwise"] = ProviderConfig{
+		AccountID: "splitwise",
+		Account:   "Splitwise",
+	}
+	err = providers.resolveAccounts(nil)
+	r.EqualError(err, "provider 'splitwise': only one of account_id or account may be configured")
+}
+
+func TestGetBudgetIDByName(t *testing.T) {
+	r := require.New(t)
+
+	catalog := &fakeCatalog{
+		budgets: ynab.BudgetsResponse{
+			Budgets: []ynab.BudgetSummary{
+				{Id: "household", Name: "Household"},
+				{Id: "business", Name: "Business"},
+			},
+		},
+	}
+	id, err := getBudgetID(context.Background(), catalog, Config{Budget: "Business"})
+	r.NoError(err)
+	r.Equal("business", id)
+
+	_, err = getBudgetID(context.Background(), catalog, Config{Budget: "Hosehold"})
+	r.EqualError(err, "budget 'Hosehold' does not exist, did you mean 'Household'?")
+}
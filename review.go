@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reviewer presents each pending transaction for the user to accept, skip or
+// edit before it's created.
+type reviewer struct {
+	*prompter
+	// The categories which may be chosen.
+	categories   *CategoryIndex
+	categoryName func(*string) string
+	// Where skipped transactions and remembered categories are kept.
+	state *State
+	// remaining is "l" or "q" once every remaining transaction was accepted
+	// or skipped, so that those of later windows are too without asking.
+	remaining string
+}
+
+const reviewHelp = `  a  accept                  s  skip
+  p  skip permanently         m  edit the memo
+  y  edit the payee           c  choose the category
+  r  always use this category for the source category
+  l  accept this and all remaining transactions
+  q  skip this and all remaining transactions`
+
+// review returns the transactions which were accepted, saving any changes to
+// the state.
+func (r *reviewer) review(transactions []SourceTransaction) ([]SourceTransaction, error) {
+	switch r.remaining {
+	case "l":
+		return transactions, nil
+	case "q":
+		return nil, nil
+	}
+	var accepted []SourceTransaction
+	var changed bool
+	defer func() {
+		if changed {
+			if err := r.state.Save(); err != nil {
+				fmt.Fprintf(r.out, "could not save state: %s\n", err)
+			}
+		}
+	}()
+
+	for i := 0; i < len(transactions); i++ {
+		t := &transactions[i]
+		r.show(i, len(transactions), *t)
+	prompt:
+		for {
+			action, err := r.ask("action (? for help)", "a")
+			if err != nil {
+				return nil, err
+			}
+			switch action {
+			case "a":
+				accepted = append(accepted, *t)
+				break prompt
+			case "s":
+				break prompt
+			case "p":
+				r.state.ignore(t.Source.Provider, t.Source.ID)
+				changed = true
+				break prompt
+			case "m":
+				if t.Memo, err = r.ask("memo", t.Memo); err != nil {
+					return nil, err
+				}
+			case "y":
+				if t.PayeeName, err = r.ask("payee", t.PayeeName); err != nil {
+					return nil, err
+				}
+			case "c":
+				if err := r.chooseCategory(t); err != nil {
+					return nil, err
+				}
+			case "r":
+				if t.CategoryId == nil || t.Source.categoryKey() == "" {
+					fmt.Fprintln(r.out, "choose a category for a transaction with a source category first")
+					continue
+				}
+				r.state.learnCategory(t.Source.Provider, t.Source.categoryKey(), *t.CategoryId)
+				changed = true
+				n := r.applyCategory(transactions[i+1:], *t)
+				fmt.Fprintf(r.out, "'%s' will always be imported as '%s' (%d more pending)\n",
+					t.Source.categoryPath(), r.categoryName(t.CategoryId), n)
+			case "l":
+				r.remaining = action
+				return append(accepted, transactions[i:]...), nil
+			case "q":
+				r.remaining = action
+				return accepted, nil
+			case "?":
+				fmt.Fprintln(r.out, reviewHelp)
+				continue
+			default:
+				fmt.Fprintf(r.out, "unknown action '%s'\n", action)
+				continue
+			}
+			r.show(i, len(transactions), *t)
+		}
+	}
+	return accepted, nil
+}
+
+func (r *reviewer) show(i, n int, t SourceTransaction) {
+	category := r.categoryName(t.CategoryId)
+	if category == "" {
+		category = "-"
+	}
+	if t.Source.Category != "" {
+		category += fmt.Sprintf(" (%s: %s)", t.Source.Provider, t.Source.Category)
+	}
+	fmt.Fprintf(r.out, "\n[%d/%d] %s %s\n", i+1, n, t.Source.Provider, t.Source.ID)
+	fmt.Fprintf(r.out, "  date:     %s\n", t.Date.String())
+	fmt.Fprintf(r.out, "  payee:    %s\n", t.PayeeName)
+	fmt.Fprintf(r.out, "  memo:     %s\n", t.Memo)
+	fmt.Fprintf(r.out, "  amount:   %s\n", formatMilliUnits(t.Amount))
+	fmt.Fprintf(r.out, "  category: %s\n", category)
+	if len(t.Trace) > 0 {
+		fmt.Fprintf(r.out, "  rules:    %s\n", strings.Join(t.Trace, ", "))
+	}
+	if s := t.Suggestion; s != nil && !s.Applied {
+		fmt.Fprintf(r.out, "  suggest:  %s (%s)\n", r.categoryName(&s.CategoryID), s)
+	}
+}
+
+func (r *reviewer) chooseCategory(t *SourceTransaction) error {
+	// Names shared by categories in different groups are qualified so that
+	// they can be told apart.
+	categories := r.categories.Categories()
+	names := make([]string, len(categories))
+	for i, c := range categories {
+		names[i] = r.categories.UniqueName(c.Id)
+	}
+	r.list(names)
+	i, err := r.askIndex("category (empty for none)", len(names), -1, true)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		t.CategoryId = nil
+		t.Source.Unmapped = false
+		return nil
+	}
+	id := categories[i].Id
+	t.CategoryId = &id
+	t.Source.Unmapped = false
+	return nil
+}
+
+// applyCategory sets the category of t on the pending transactions from the
+// same source category, even those mapped to another one, returning how many
+// were changed.
+func (r *reviewer) applyCategory(pending []SourceTransaction, t SourceTransaction) int {
+	var n int
+	for i := range pending {
+		p := &pending[i]
+		if p.Source.Provider != t.Source.Provider || p.Source.categoryKey() != t.Source.categoryKey() {
+			continue
+		}
+		if p.CategoryId == nil || *p.CategoryId != *t.CategoryId {
+			n++
+		}
+		id := *t.CategoryId
+		p.CategoryId = &id
+		p.Source.Unmapped = false
+	}
+	return n
+}
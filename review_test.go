@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewer(t *testing.T) {
+	r := require.New(t)
+
+	categories := []ynab.Category{
+		{Id: "groceries", Name: "Groceries"},
+		{Id: "old", Name: "Old", Hidden: true},
+		{Id: "dining", Name: "Dining Out"},
+	}
+	categoryName := func(id *string) string {
+		for _, c := range categories {
+			if id != nil && c.Id == *id {
+				return c.Name
+			}
+		}
+		return ""
+	}
+	pending := func(id, category string) SourceTransaction {
+		return SourceTransaction{
+			Transaction: ynab.Transaction{Memo: "expense " + id, Amount: -1000},
+			Source:      SourceRecord{Provider: "splitwise", ID: id, Category: category},
+		}
+	}
+	state, err := loadState(t.TempDir())
+	r.NoError(err)
+
+	input := strings.Join([]string{
+		// Choose Dining Out and remember it for Dining out, then accept.
+		"c", "2", "r", "",
+		// Edit the memo of the next one, which now has a category too.
+		"m", "dinner", "a",
+		// Skip the last one forever.
+		"p",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	review := &reviewer{
+		prompter:     newPrompter(strings.NewReader(input), &out),
+		categories:   newCategoryIndex([]ynab.CategoryGroup{{Categories: categories}}),
+		categoryName: categoryName,
+		state:        state,
+	}
+	accepted, err := review.review([]SourceTransaction{
+		pending("1", "Dining out"),
+		pending("2", "Dining out"),
+		pending("3", "Groceries"),
+	})
+	r.NoError(err)
+	r.Len(accepted, 2)
+	r.Equal("dining", *accepted[0].CategoryId)
+	r.Equal("dining", *accepted[1].CategoryId)
+	r.Equal("dinner", accepted[1].Memo)
+	r.Contains(out.String(), "'Dining out' will always be imported as 'Dining Out' (1 more pending)")
+
+	// The decisions are applied to later runs.
+	state, err = loadState(filepath.Dir(state.path))
+	r.NoError(err)
+	next := state.apply([]SourceTransaction{
+		pending("3", "Groceries"),
+		pending("4", "Dining out"),
+	})
+	r.Len(next, 1)
+	r.Equal("4", next[0].Source.ID)
+	r.Equal("dining", *next[0].CategoryId)
+}
+
+func TestReviewerAcceptAll(t *testing.T) {
+	r := require.New(t)
+
+	var out bytes.Buffer
+	review := &reviewer{
+		prompter:     newPrompter(strings.NewReader("s\nl\n"), &out),
+		categoryName: func(*string) string { return "" },
+	}
+	accepted, err := review.review([]SourceTransaction{
+		{Source: SourceRecord{ID: "1"}},
+		{Source: SourceRecord{ID: "2"}},
+		{Source: SourceRecord{ID: "3"}},
+	})
+	r.NoError(err)
+	r.Len(accepted, 2)
+	r.Equal("2", accepted[0].Source.ID)
+}
+
+func TestReviewerRememberOverridesMapping(t *testing.T) {
+	r := require.New(t)
+
+	categories := newCategoryIndex([]ynab.CategoryGroup{{Categories: []ynab.Category{
+		{Id: "groceries", Name: "Groceries"},
+		{Id: "household", Name: "Household"},
+	}}})
+	// Both Splitwise categories are named Other, under different parents.
+	mapped := func(id, key, parent string) SourceTransaction {
+		groceries := "groceries"
+		return SourceTransaction{
+			Transaction: ynab.Transaction{CategoryId: &groceries, Amount: -1000},
+			Source: SourceRecord{Provider: "splitwise", ID: id, Category: "Other",
+				ParentCategory: parent, CategoryKey: key},
+		}
+	}
+	state, err := loadState(t.TempDir())
+	r.NoError(err)
+
+	// Choose Household instead of the mapped Groceries and remember it.
+	var out bytes.Buffer
+	review := &reviewer{
+		prompter:     newPrompter(strings.NewReader("c\n2\nr\n\n\n\n"), &out),
+		categories:   categories,
+		categoryName: categories.Name,
+		state:        state,
+	}
+	accepted, err := review.review([]SourceTransaction{
+		mapped("1", "#18", "Home"),
+		mapped("2", "#18", "Home"),
+		mapped("3", "#19", "Food and drink"),
+	})
+	r.NoError(err)
+	r.Len(accepted, 3)
+	r.Equal("household", *accepted[1].CategoryId)
+	r.Equal("groceries", *accepted[2].CategoryId, "only the same source category is changed")
+	r.Contains(out.String(), "'Home / Other' will always be imported as 'Household' (1 more pending)")
+
+	// The remembered category takes precedence over the mapping in later
+	// runs, but only for the same source category.
+	next := state.apply([]SourceTransaction{mapped("4", "#18", "Home"), mapped("5", "#19", "Food and drink")})
+	r.Equal("household", *next[0].CategoryId)
+	r.Equal("groceries", *next[1].CategoryId)
+}
+
+func TestReviewerChooseQualifiedCategory(t *testing.T) {
+	r := require.New(t)
+
+	categories := newCategoryIndex([]ynab.CategoryGroup{
+		{Name: "Everyday", Categories: []ynab.Category{{Id: "groceries", Name: "Groceries"}, {Id: "misc-1", Name: "Misc"}}},
+		{Name: "Bills", Categories: []ynab.Category{{Id: "misc-2", Name: "Misc"}}},
+	})
+	var out bytes.Buffer
+	review := &reviewer{
+		prompter:     newPrompter(strings.NewReader("c\n3\na\n"), &out),
+		categories:   categories,
+		categoryName: categories.Name,
+	}
+	accepted, err := review.review([]SourceTransaction{{Source: SourceRecord{ID: "1"}}})
+	r.NoError(err)
+	r.Equal("misc-2", *accepted[0].CategoryId)
+	r.Contains(out.String(), "  1) Groceries\n")
+	r.Contains(out.String(), "  2) Everyday: Misc\n")
+	r.Contains(out.String(), "  3) Bills: Misc\n")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"budgetbridge/ynab"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Rules transform the transactions of every provider before they're created.
+//
+// Each transaction is passed through the rules in order. Every rule which
+// matches it is applied, until one drops it or stops the rules after it.
+type Rules []Rule
+
+// A Rule changes or drops the transactions which it matches.
+type Rule struct {
+	// Name identifies the rule in the trace of a transaction. It defaults to
+	// the position of the rule.
+	Name  string    `json:"name"`
+	Match RuleMatch `json:"match"`
+	Set   RuleSet   `json:"set"`
+	// Drop skips the matching transactions entirely.
+	Drop bool `json:"drop"`
+	// Stop skips the rules after this one for the matching transactions.
+	Stop bool `json:"stop"`
+}
+
+// RuleMatch selects the transactions a rule applies to. Every condition which
+// is set must hold, so an empty match applies to every transaction.
+//
+// Names are compared without regard to case.
+type RuleMatch struct {
+	Provider string `json:"provider"`
+	// Description is a regular expression matched against the description of
+	// the source record.
+	Description *Regexp `json:"description"`
+	Payee       string  `json:"payee"`
+	// Group is the name of the group within the provider, such as a
+	// Splitwise group.
+	Group string `json:"group"`
+	// Category is the name of the category within the provider.
+	Category string       `json:"category"`
+	Amount   *AmountRange `json:"amount"`
+	// Since and Until bound the date of the transaction. Until is exclusive.
+	Since *ynab.Date `json:"since"`
+	Until *ynab.Date `json:"until"`
+}
+
+// AmountRange is an inclusive range of amounts in the currency of the budget,
+// where outflows are negative. Either bound may be omitted.
+type AmountRange struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// RuleSet is what a rule changes in the transactions it matches.
+type RuleSet struct {
+	// The YNAB category, by ID or by name.
+	CategoryID string  `json:"category_id"`
+	Category   string  `json:"category"`
+	Payee      *string `json:"payee"`
+	Memo       *string `json:"memo"`
+	// FlagColor may be empty to clear the flag.
+	FlagColor *ynab.FlagColor     `json:"flag_color"`
+	Cleared   *ynab.ClearedStatus `json:"cleared"`
+	Approved  *bool               `json:"approved"`
+	// The YNAB account, by ID or by name.
+	AccountID string `json:"account_id"`
+	Account   string `json:"account"`
+}
+
+// Regexp is a regular expression written as a string.
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (re *Regexp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	compiled, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	re.Regexp = compiled
+	return nil
+}
+
+func (re Regexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(re.String())
+}
+
+func (rules *Rules) UnmarshalJSON(data []byte) error {
+	var raw []Rule
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for i := range raw {
+		r := &raw[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rule '%s': %s", r.Name, err)
+		}
+	}
+	*rules = raw
+	return nil
+}
+
+func (r Rule) validate() error {
+	set := r.Set
+	switch {
+	case set.CategoryID != "" && set.Category != "":
+		return fmt.Errorf("only one of category_id or category may be set")
+	case set.AccountID != "" && set.Account != "":
+		return fmt.Errorf("only one of account_id or account may be set")
+	case set.FlagColor != nil && *set.FlagColor != "" && !set.FlagColor.Valid():
+		return fmt.Errorf("unknown flag_color '%s', expected one of %s", *set.FlagColor, flagColorNames())
+	case set.Cleared != nil && !set.Cleared.Valid():
+		return fmt.Errorf("unknown cleared status '%s', expected one of %s", *set.Cleared, clearedStatusNames())
+	case r.Drop && set != RuleSet{}:
+		return fmt.Errorf("a rule which drops transactions cannot also set anything")
+	}
+	if amount := r.Match.Amount; amount != nil && amount.Min != nil && amount.Max != nil && *amount.Min > *amount.Max {
+		return fmt.Errorf("amount min is greater than max")
+	}
+	return nil
+}
+
+func (rules Rules) categoryRefs() []categoryRef {
+	var refs []categoryRef
+	for _, r := range rules {
+		if r.Set.CategoryID != "" || r.Set.Category != "" {
+			refs = append(refs, categoryRef{
+				ID:     r.Set.CategoryID,
+				Name:   r.Set.Category,
+				Source: fmt.Sprintf("rules: rule '%s'", r.Name),
+			})
+		}
+	}
+	return refs
+}
+
+// resolveCategories sets the YNAB category ID of every rule which only names
+// its category.
+func (rules Rules) resolveCategories(resolve func(string) (string, error)) error {
+	for i := range rules {
+		set := &rules[i].Set
+		if set.Category == "" {
+			continue
+		}
+		id, err := resolve(set.Category)
+		if err != nil {
+			return fmt.Errorf("rule '%s': %s", rules[i].Name, err)
+		}
+		set.CategoryID, set.Category = id, ""
+	}
+	return nil
+}
+
+// hasAccountNames reports whether any rule sets the account by name.
+func (rules Rules) hasAccountNames() bool {
+	for _, r := range rules {
+		if r.Set.Account != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// accountNames returns the names of the accounts the rules set.
+func (rules Rules) accountNames() []string {
+	var names []string
+	for _, r := range rules {
+		if r.Set.Account != "" {
+			names = append(names, r.Set.Account)
+		}
+	}
+	return names
+}
+
+// resolveAccounts sets the account ID of every rule which only names its
+// account.
+func (rules Rules) resolveAccounts(accounts []ynab.Account) error {
+	for i := range rules {
+		set := &rules[i].Set
+		if set.Account == "" {
+			continue
+		}
+		account, err := findAccountByName(accounts, set.Account)
+		if err != nil {
+			return fmt.Errorf("rule '%s': %s", rules[i].Name, err)
+		}
+		set.AccountID, set.Account = account.Id, ""
+	}
+	return nil
+}
+
+// apply passes each transaction through the rules, returning those which
+// were kept and those which were dropped. The name of every rule which fired
+// is added to the trace of the transaction.
+func (rules Rules) apply(transactions []SourceTransaction) (kept, dropped []SourceTransaction) {
+	for _, t := range transactions {
+		if rules.applyTo(&t) {
+			kept = append(kept, t)
+		} else {
+			dropped = append(dropped, t)
+		}
+	}
+	return kept, dropped
+}
+
+// applyTo applies the rules to a transaction, returning false if it was
+// dropped.
+func (rules Rules) applyTo(t *SourceTransaction) bool {
+	for _, r := range rules {
+		if !r.Match.matches(*t) {
+			continue
+		}
+		t.Trace = append(t.Trace, r.Name)
+		if r.Drop {
+			log.Debug().
+				Str("provider", t.Source.Provider).
+				Str("id", t.Source.ID).
+				Str("rule", r.Name).
+				Msg("transaction dropped by rule")
+			return false
+		}
+		r.Set.apply(t)
+		if r.Stop {
+			break
+		}
+	}
+	return true
+}
+
+func (m RuleMatch) matches(t SourceTransaction) bool {
+	switch {
+	case m.Provider != "" && !strings.EqualFold(m.Provider, t.Source.Provider):
+		return false
+	case m.Description != nil && !m.Description.MatchString(t.Source.Description):
+		return false
+	case m.Payee != "" && !strings.EqualFold(m.Payee, t.PayeeName):
+		return false
+	case m.Group != "" && !strings.EqualFold(m.Group, t.Source.Group):
+		return false
+	case m.Category != "" && !strings.EqualFold(m.Category, t.Source.Category):
+		return false
+	case m.Since != nil && t.Date.Time().Before(m.Since.Time()):
+		return false
+	case m.Until != nil && !t.Date.Time().Before(m.Until.Time()):
+		return false
+	}
+	return m.Amount == nil || m.Amount.contains(t.Amount)
+}
+
+// contains reports whether an amount in milliunits is within the range.
+func (ar AmountRange) contains(milliunits int) bool {
+	toMilliUnits := func(amount float64) int {
+		return int(math.Round(amount * milliunitsPerDollar))
+	}
+	if ar.Min != nil && milliunits < toMilliUnits(*ar.Min) {
+		return false
+	}
+	if ar.Max != nil && milliunits > toMilliUnits(*ar.Max) {
+		return false
+	}
+	return true
+}
+
+func (set RuleSet) apply(t *SourceTransaction) {
+	if set.CategoryID != "" {
+		id := set.CategoryID
+		t.CategoryId = &id
+		t.Source.Unmapped = false
+	}
+	if set.Payee != nil {
+		t.PayeeName = *set.Payee
+	}
+	if set.Memo != nil {
+		t.Memo = *set.Memo
+	}
+	if set.FlagColor != nil {
+		// An empty color clears the flag.
+		if *set.FlagColor == "" {
+			t.FlagColor = nil
+		} else {
+			color := *set.FlagColor
+			t.FlagColor = &color
+		}
+	}
+	if set.Cleared != nil {
+		t.Cleared = *set.Cleared
+	}
+	if set.Approved != nil {
+		t.Approved = *set.Approved
+	}
+	if set.AccountID != "" {
+		t.AccountId = set.AccountID
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesApply(t *testing.T) {
+	r := require.New(t)
+
+	var rules Rules
+	r.NoError(json.Unmarshal([]byte(`[
+		{"name": "settle up", "match": {"description": "(?i)^payment"}, "drop": true},
+		{"name": "rent", "match": {"group": "apartment", "amount": {"max": -500}},
+		 "set": {"category_id": "rent", "flag_color": "blue", "cleared": "cleared"}, "stop": true},
+		{"match": {"category": "groceries"}, "set": {"category_id": "groceries", "approved": true}},
+		{"name": "summer", "match": {"since": "2020-06-01", "until": "2020-09-01"}, "set": {"memo": "summer"}}
+	]`), &rules))
+	r.Equal("rule 3", rules[2].Name)
+
+	transactions := []SourceTransaction{
+		{
+			Transaction: ynab.Transaction{Amount: -10000, Date: ynab.Date(date(2020, 7, 1))},
+			Source:      SourceRecord{ID: "1", Description: "Payment", Category: "General"},
+		},
+		{
+			Transaction: ynab.Transaction{Amount: -600000, Date: ynab.Date(date(2020, 7, 1))},
+			Source:      SourceRecord{ID: "2", Description: "Rent", Group: "Apartment", Category: "Groceries"},
+		},
+		{
+			Transaction: ynab.Transaction{Amount: -20000, Date: ynab.Date(date(2020, 7, 1))},
+			Source:      SourceRecord{ID: "3", Description: "Food", Group: "Apartment", Category: "Groceries"},
+		},
+		{
+			Transaction: ynab.Transaction{Amount: -20000, Date: ynab.Date(date(2020, 9, 1)), Memo: "Food"},
+			Source:      SourceRecord{ID: "4", Description: "Food"},
+		},
+	}
+	kept, dropped := rules.apply(transactions)
+
+	r.Len(dropped, 1)
+	r.Equal("1", dropped[0].Source.ID)
+	r.Equal([]string{"settle up"}, dropped[0].Trace)
+
+	r.Len(kept, 3)
+	r.Equal([]string{"rent"}, kept[0].Trace, "later rules are skipped after a stop")
+	r.Equal("rent", *kept[0].CategoryId)
+	r.Equal(ynab.FlagBlue, *kept[0].FlagColor)
+	r.Equal(ynab.Cleared, kept[0].Cleared)
+	r.False(kept[0].Approved)
+
+	r.Equal([]string{"rule 3", "summer"}, kept[1].Trace, "every matching rule is applied in order")
+	r.Equal("groceries", *kept[1].CategoryId)
+	r.True(kept[1].Approved)
+	r.Equal("summer", kept[1].Memo)
+
+	r.Empty(kept[2].Trace, "until is exclusive")
+	r.Equal("Food", kept[2].Memo)
+}
+
+func TestRulesValidate(t *testing.T) {
+	for config, msg := range map[string]string{
+		`[{"set": {"flag_color": "pink"}}]`:                             "rule 'rule 1': unknown flag_color 'pink', expected one of red, orange, yellow, green, blue, purple",
+		`[{"set": {"cleared": "maybe"}}]`:                               "rule 'rule 1': unknown cleared status 'maybe', expected one of cleared, uncleared, reconciled",
+		`[{"name": "x", "set": {"category": "a", "category_id": "b"}}]`: "rule 'x': only one of category_id or category may be set",
+		`[{"set": {"memo": ""}, "drop": true}]`:                         "rule 'rule 1': a rule which drops transactions cannot also set anything",
+		`[{"match": {"amount": {"min": 1, "max": 0}}}]`:                 "rule 'rule 1': amount min is greater than max",
+		`[{"match": {"description": "("}}]`:                             "error parsing regexp: missing closing ): `(`",
+	} {
+		var rules Rules
+		require.EqualError(t, json.Unmarshal([]byte(config), &rules), msg, config)
+	}
+}
+
+func TestRulesResolve(t *testing.T) {
+	r := require.New(t)
+
+	rules := Rules{
+		{Name: "a", Set: RuleSet{Category: "Groceries", Account: "Checking"}},
+		{Name: "b", Set: RuleSet{CategoryID: "fun"}},
+	}
+	r.True(rules.hasAccountNames())
+	r.Len(rules.categoryRefs(), 2)
+
+	groups := []ynab.CategoryGroup{{Name: "Everyday", Categories: []ynab.Category{{Id: "groceries", Name: "Groceries"}}}}
+	r.NoError(rules.resolveCategories(newCategoryIndex(groups).resolver()))
+	r.Equal("groceries", rules[0].Set.CategoryID)
+	r.NoError(rules.resolveAccounts([]ynab.Account{{Id: "checking", Name: "Checking"}}))
+	r.Equal("checking", rules[0].Set.AccountID)
+	r.False(rules.hasAccountNames())
+
+	rules = Rules{{Name: "c", Set: RuleSet{Account: "Savings"}}}
+	r.EqualError(rules.resolveAccounts(nil), "rule 'c': account 'Savings' does not exist")
+}
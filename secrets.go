@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Any string value in the config may instead be loaded from an external source
+// by setting a key with one of these suffixes in its place. For example,
+// "client_secret_cmd": "pass show splitwise" sets "client_secret" to the output
+// of the command.
+const (
+	// The name of an environment variable.
+	secretEnvSuffix = "_env"
+	// The path of a file. Trailing newlines are removed.
+	secretFileSuffix = "_file"
+	// A shell command whose output is used, e.g. to query a password manager.
+	// Trailing newlines are removed.
+	secretCmdSuffix = "_cmd"
+	// The name of an entry in the encrypted vault.
+	secretVaultSuffix = "_vault"
+)
+
+// secretResolver replaces secret references within a decoded config with their values.
+type secretResolver struct {
+	// vault is used for any _vault references. If nil, they are an error.
+	vault *Vault
+}
+
+func (sr *secretResolver) resolve(prefix string, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return sr.resolveMap(prefix, v)
+	case []interface{}:
+		for i, elem := range v {
+			if err := sr.resolve(fmt.Sprintf("%s[%d]", prefix, i), elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sr *secretResolver) resolveMap(prefix string, m map[string]interface{}) error {
+	// Sorted so that errors and any commands run are deterministic.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		path := joinKey(prefix, k)
+		suffix := secretSuffix(k)
+		if suffix == "" {
+			if err := sr.resolve(path, m[k]); err != nil {
+				return err
+			}
+			continue
+		}
+		ref, ok := m[k].(string)
+		if !ok {
+			return &configError{Key: path, Err: fmt.Errorf("expected a string")}
+		}
+		target := strings.TrimSuffix(k, suffix)
+		if _, ok := m[target]; ok {
+			return &configError{Key: path, Err: fmt.Errorf("cannot be set along with %s", joinKey(prefix, target))}
+		}
+		secret, err := sr.lookup(suffix, ref)
+		if err != nil {
+			return &configError{Key: path, Err: err}
+		}
+		delete(m, k)
+		m[target] = secret
+	}
+	return nil
+}
+
+func (sr *secretResolver) lookup(suffix, ref string) (string, error) {
+	switch suffix {
+	case secretEnvSuffix:
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return value, nil
+	case secretFileSuffix:
+		contents, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	case secretCmdSuffix:
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", ref)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("command failed: %s", err)
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	case secretVaultSuffix:
+		if sr.vault == nil {
+			return "", fmt.Errorf("no vault is configured")
+		}
+		return sr.vault.Get(ref)
+	}
+	panic("unreachable")
+}
+
+func secretSuffix(key string) string {
+	for _, suffix := range []string{secretEnvSuffix, secretFileSuffix, secretCmdSuffix, secretVaultSuffix} {
+		if strings.HasSuffix(key, suffix) && len(key) > len(suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	r.NoError(ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600))
+	os.Setenv("BUDGETBRIDGE_TEST_SECRET", "from-env")
+	defer os.Unsetenv("BUDGETBRIDGE_TEST_SECRET")
+
+	vault := &Vault{
+		Path:       filepath.Join(dir, "vault"),
+		Passphrase: func() ([]byte, error) { return []byte("hunter2"), nil },
+	}
+	r.NoError(vault.Set("ynab", "from-vault"))
+
+	tree := map[string]interface{}{
+		"access_token_vault": "ynab",
+		"providers": map[string]interface{}{
+			"splitwise": map[string]interface{}{
+				"options": map[string]interface{}{
+					"client_key_env":    "BUDGETBRIDGE_TEST_SECRET",
+					"client_secret_cmd": "echo from-cmd",
+					"token_cache_file":  secretFile,
+				},
+			},
+		},
+	}
+	r.NoError((&secretResolver{vault: vault}).resolve("", tree))
+	r.Equal("from-vault", tree["access_token"])
+	options := tree["providers"].(map[string]interface{})["splitwise"].(map[string]interface{})["options"]
+	r.Equal(map[string]interface{}{
+		"client_key":    "from-env",
+		"client_secret": "from-cmd",
+		"token_cache":   "from-file",
+	}, options)
+}
+
+func TestResolveSecretsConflict(t *testing.T) {
+	r := require.New(t)
+
+	tree := map[string]interface{}{
+		"access_token":     "inline",
+		"access_token_cmd": "echo other",
+	}
+	err := (&secretResolver{}).resolve("", tree)
+	r.EqualError(err, "access_token_cmd: cannot be set along with access_token")
+
+	tree = map[string]interface{}{
+		"access_token_vault": "ynab",
+	}
+	err = (&secretResolver{}).resolve("", tree)
+	r.EqualError(err, "access_token_vault: no vault is configured")
+}
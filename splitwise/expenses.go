@@ -69,14 +69,19 @@ type ExpenseUser struct {
 }
 
 type Expense struct {
-	ID          int           `json:"id"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
-	DeletedAt   *time.Time    `json:"deleted_at"`
-	Category    Category      `json:"category"`
-	Cost        string        `json:"cost"`
-	Description string        `json:"description"`
-	Users       []ExpenseUser `json:"users"`
+	ID        int        `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	// GroupID is nil for expenses outside of any group.
+	GroupID      *int          `json:"group_id"`
+	Category     Category      `json:"category"`
+	Cost         string        `json:"cost"`
+	CurrencyCode string        `json:"currency_code"`
+	Description  string        `json:"description"`
+	Users        []ExpenseUser `json:"users"`
+	// CommentsCount is the number of comments on the expense.
+	CommentsCount int `json:"comments_count"`
 }
 
 type GetExpensesRequest struct {
@@ -175,7 +180,7 @@ func (c *Client) GetExpenses(ctx context.Context, req *GetExpensesRequest) ([]Ex
 	if err := c.do(ctx, http.MethodGet, u, nil, &res); err != nil {
 		return nil, err
 	}
-	req.Offset = len(res.Expenses)
+	req.Offset += len(res.Expenses)
 	return res.Expenses, nil
 }
 
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"budgetbridge/splitwise"
+	"budgetbridge/ynab"
+)
+
+// An AmountStrategy decides how much of a shared expense is imported.
+type AmountStrategy string
+
+const (
+	// The net balance of the expense: what you're owed, or what you owe as
+	// an outflow. This suits an account which tracks the balance with your
+	// friends.
+	amountNetBalance AmountStrategy = "net_balance"
+	// Only your share of the expense, as an outflow.
+	amountOwedShare AmountStrategy = "owed_share"
+	// What you paid towards the expense, as an outflow.
+	amountPaidShare AmountStrategy = "paid_share"
+	// The net balance, split into your share of the expense as an outflow
+	// in its category, and what you paid as an uncategorized inflow which
+	// offsets it. This suits a liability account for Splitwise where only
+	// your share is spending.
+	amountOwedShareOffset AmountStrategy = "owed_share_offset"
+)
+
+var amountStrategies = []AmountStrategy{amountNetBalance, amountOwedShare, amountPaidShare, amountOwedShareOffset}
+
+// errNothingToImport is returned for expenses which come to nothing under the
+// amount strategy, such as those paid by someone else under paid_share.
+var errNothingToImport = errors.New("nothing to import under the amount strategy")
+
+func (s *AmountStrategy) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, strategy := range amountStrategies {
+		if AmountStrategy(raw) == strategy {
+			*s = strategy
+			return nil
+		}
+	}
+	names := make([]string, len(amountStrategies))
+	for i, strategy := range amountStrategies {
+		names[i] = string(strategy)
+	}
+	return fmt.Errorf("unknown amount_strategy '%s', expected one of %s", raw, strings.Join(names, ", "))
+}
+
+func (s AmountStrategy) String() string {
+	if s == "" {
+		return string(amountNetBalance)
+	}
+	return string(s)
+}
+
+// skipsZero reports whether expenses which come to nothing are left out.
+// Under the other strategies they still record that the expense was shared.
+func (s AmountStrategy) skipsZero() bool {
+	return s == amountOwedShare || s == amountPaidShare
+}
+
+// expenseAmount is the amount of a transaction for an expense.
+type expenseAmount struct {
+	Amount          int
+	Subtransactions []ynab.SubTransaction
+	// uncategorized is set when none of the amount is your share of the
+	// expense, so that it belongs in no category.
+	uncategorized bool
+	// description explains the amount.
+	description string
+}
+
+// amount returns the amount of the transaction for the share of an expense
+// belonging to a user.
+func (s AmountStrategy) amount(user splitwise.ExpenseUser) (expenseAmount, error) {
+	net, err := amountToMilliUnits(user.NetBalance)
+	if err != nil {
+		return expenseAmount{}, fmt.Errorf("net balance '%s': %s", user.NetBalance, err)
+	}
+	owed, err := amountToMilliUnits(user.OwedShare)
+	if err != nil {
+		return expenseAmount{}, fmt.Errorf("owed share '%s': %s", user.OwedShare, err)
+	}
+	paid, err := amountToMilliUnits(user.PaidShare)
+	if err != nil {
+		return expenseAmount{}, fmt.Errorf("paid share '%s': %s", user.PaidShare, err)
+	}
+
+	switch s {
+	case amountOwedShare:
+		return expenseAmount{
+			Amount:      -owed,
+			description: fmt.Sprintf("owed share '%s' is %s", user.OwedShare, formatMilliUnits(-owed)),
+		}, nil
+	case amountPaidShare:
+		return expenseAmount{
+			Amount:      -paid,
+			description: fmt.Sprintf("paid share '%s' is %s", user.PaidShare, formatMilliUnits(-paid)),
+		}, nil
+	case amountOwedShareOffset:
+		a := expenseAmount{Amount: net}
+		switch {
+		case paid == 0:
+			a.description = fmt.Sprintf("owed share '%s' is %s, and nothing was paid to offset it",
+				user.OwedShare, formatMilliUnits(-owed))
+		case owed == 0:
+			a.uncategorized = true
+			a.description = fmt.Sprintf("paid share '%s' is %s, and none of it is owed",
+				user.PaidShare, formatMilliUnits(paid))
+		default:
+			a.Subtransactions = []ynab.SubTransaction{
+				{Amount: -owed, Memo: "Your share"},
+				{Amount: paid, Memo: "Paid by you"},
+			}
+			a.description = fmt.Sprintf("net balance '%s' is %s, split into owed share %s and paid share %s",
+				user.NetBalance, formatMilliUnits(net), formatMilliUnits(-owed), formatMilliUnits(paid))
+		}
+		return a, nil
+	default:
+		return expenseAmount{
+			Amount:      net,
+			description: fmt.Sprintf("net balance '%s' is %d milliunits (%s)", user.NetBalance, net, formatMilliUnits(net)),
+		}, nil
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"budgetbridge/ynab"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -18,12 +20,31 @@ import (
 
 type splitwiseClient interface {
 	GetExpenses(context.Context, *splitwise.GetExpensesRequest) ([]splitwise.Expense, error)
+	GetExpense(context.Context, int) (*splitwise.Expense, error)
+	GetGroups(context.Context) ([]splitwise.Group, error)
+	GetCategories(context.Context) (*splitwise.GetCategoriesResponse, error)
 }
 
 type SplitwiseTransactionProvider struct {
 	userID          int
 	client          splitwiseClient
 	categoryMapping CategoryMapping
+	// defaultCategory is the YNAB category ID of expenses with no mapping.
+	defaultCategory string
+	// groupNames maps group IDs to their names, once loaded.
+	groupNames map[int]string
+	// categoryParents maps the IDs of subcategories to their parents, once
+	// loaded.
+	categoryParents map[int]splitwise.Category
+	// unmapped counts the expenses in each unmapped category.
+	unmapped map[string]int
+	// broken counts the expenses for each mapping entry whose YNAB category
+	// is unknown, hidden or deleted, by the label of the entry.
+	broken map[string]int
+	// memoTemplate and payeeTemplate render the memo and payee of each
+	// transaction if set.
+	memoTemplate, payeeTemplate *Template
+	amountStrategy              AmountStrategy
 }
 
 type SplitwiseOptions struct {
@@ -31,46 +52,138 @@ type SplitwiseOptions struct {
 	ClientKey       string          `json:"client_key"`
 	ClientSecret    string          `json:"client_secret"`
 	TokenCache      string          `json:"token_cache"`
+	RedirectURL     string          `json:"redirect_url"`
 	CategoryMapping CategoryMapping `json:"category_mapping"`
+	// The YNAB category, by ID or by name, of expenses with no mapping.
+	DefaultYnabId   string `json:"default_ynab_id"`
+	DefaultYnabName string `json:"default_ynab_name"`
+	// MemoTemplate and PayeeTemplate render the memo and payee of each
+	// transaction from a splitwiseRecord, instead of using the description
+	// of the expense and the first name of the friend it's shared with.
+	MemoTemplate  *Template `json:"memo_template"`
+	PayeeTemplate *Template `json:"payee_template"`
+	// AmountStrategy decides how much of each expense is imported, by
+	// default its net balance.
+	AmountStrategy AmountStrategy `json:"amount_strategy"`
 }
 
+// splitwiseRecord describes an expense to the memo and payee templates.
+type splitwiseRecord struct {
+	Description string
+	// Group is the name of the group of the expense, if it has one.
+	Group string
+	// Category is the name of the Splitwise category, and ParentCategory
+	// the name of its parent if known.
+	Category, ParentCategory string
+	// Participants are the full names of everyone sharing the expense.
+	Participants []string
+	// Friend is the first name of the person the expense is shared with.
+	Friend string
+	// Currency and Cost are the currency and the total cost of the expense
+	// as it was entered.
+	Currency, Cost string
+	CommentsCount  int
+	Date           time.Time
+	// Expense is the expense as loaded from Splitwise.
+	Expense splitwise.Expense
+}
+
+// sampleSplitwiseRecord is what templates are rendered with to check them
+// when the config is loaded.
+var sampleSplitwiseRecord = splitwiseRecord{
+	Description:    "Groceries",
+	Group:          "Apartment",
+	Category:       "Groceries",
+	ParentCategory: "Food and drink",
+	Participants:   []string{"Annie Edison", "Jeff Winger"},
+	Friend:         "Annie",
+	Currency:       "USD",
+	Cost:           "150.0",
+	Date:           time.Date(2020, 8, 9, 0, 0, 0, 0, time.UTC),
+	Expense: splitwise.Expense{
+		ID:          1,
+		Description: "Groceries",
+		Category:    splitwise.Category{ID: 12, Name: "Groceries"},
+		Users: []splitwise.ExpenseUser{
+			{User: splitwise.User{FirstName: "Annie", LastName: "Edison"}},
+			{User: splitwise.User{FirstName: "Jeff", LastName: "Winger"}},
+		},
+	},
+}
+
+// splitwiseCategoryPathSep separates the parent from the subcategory in a
+// path such as "Utilities / Electricity".
+const splitwiseCategoryPathSep = " / "
+
+// CategoryMapping maps Splitwise categories to YNAB categories. Entries are
+// keyed by the ID of their Splitwise category, or else by its name or path.
 type CategoryMapping map[string]CategoryMappingEntry
 
+// categoryIDKey is the key of an entry for the Splitwise category with an ID.
+func categoryIDKey(id int) string {
+	return "#" + strconv.Itoa(id)
+}
+
+// match finds the entry for a Splitwise category, trying its ID, its path and
+// its name, and then the ID and name of its parent. It returns how the entry
+// was found for use in messages.
+func (cm CategoryMapping) match(category splitwise.Category, parent *splitwise.Category) (CategoryMappingEntry, string, bool) {
+	type candidate struct{ key, by string }
+	var candidates []candidate
+	if category.ID != 0 {
+		candidates = append(candidates, candidate{categoryIDKey(category.ID), "ID"})
+	}
+	if parent != nil {
+		candidates = append(candidates, candidate{parent.Name + splitwiseCategoryPathSep + category.Name, "path"})
+	}
+	candidates = append(candidates, candidate{category.Name, "name"})
+	if parent != nil {
+		candidates = append(candidates,
+			candidate{categoryIDKey(parent.ID), "parent ID"},
+			candidate{parent.Name, "parent name"},
+		)
+	}
+	for _, c := range candidates {
+		if m, ok := cm[c.key]; ok {
+			return m, c.by, true
+		}
+	}
+	return CategoryMappingEntry{}, "", false
+}
+
+// Categorize returns the YNAB category of an expense whose Splitwise category
+// has the given parent, which is nil if unknown. Only usable categories are
+// returned.
 func (cm *CategoryMapping) Categorize(
-	categories []ynab.Category,
+	categories *CategoryIndex,
 	expense splitwise.Expense,
+	parent *splitwise.Category,
 ) (string, bool) {
-	ynabCategoriesByName := make(map[string]ynab.Category, len(categories))
-	ynabCategoriesById := make(map[string]ynab.Category, len(categories))
-	for _, c := range categories {
-		ynabCategoriesByName[c.Name] = c
-		ynabCategoriesById[c.Id] = c
-	}
-
-	m, ok := (*cm)[expense.Category.Name]
+	m, _, ok := cm.match(expense.Category, parent)
 	if !ok {
 		return "", false
 	}
 	if m.YnabId != "" {
 		log.Debug().Str("id", m.YnabId).Msg("mapping to ynab ID")
-		ynabCategory, ok := ynabCategoriesById[m.YnabId]
+		ynabCategory, ok := categories.ByID(m.YnabId)
 		if !ok {
-			log.Warn().
+			log.Debug().
+				Str("name", m.label()).
 				Str("categoryID", m.YnabId).
-				Msg("unknown YNAB category ID in splitwise mapping")
+				Msg("unknown, hidden or deleted YNAB category ID in splitwise mapping")
 			return "", false
 		}
 		return ynabCategory.Id, true
 	}
 	if m.YnabName != "" {
-		log.Debug().Str("name", m.Name).Str("ynab_name", m.YnabName).Msg("mapping to ynab Name")
-		ynabCategory, ok := ynabCategoriesByName[m.YnabName]
-		if !ok {
-			log.Warn().
-				Str("name", m.Name).
+		log.Debug().Str("name", m.label()).Str("ynab_name", m.YnabName).Msg("mapping to ynab Name")
+		ynabCategory, err := categories.ByName(m.YnabName)
+		if err != nil {
+			log.Debug().
+				Err(err).
+				Str("name", m.label()).
 				Str("ynab_name", m.YnabName).
-				Msg("unknown YNAB category name in splitwise mapping")
-			fmt.Printf("%+v\n", ynabCategoriesByName)
+				Msg("unusable YNAB category name in splitwise mapping")
 			return "", false
 		}
 		return ynabCategory.Id, true
@@ -78,6 +191,63 @@ func (cm *CategoryMapping) Categorize(
 	return "", false
 }
 
+// describe explains how the category of an expense was mapped.
+func (cm CategoryMapping) describe(expense splitwise.Expense, parent *splitwise.Category, categoryID string, ok bool) string {
+	m, by, found := cm.match(expense.Category, parent)
+	switch {
+	case !found:
+		return fmt.Sprintf("no category_mapping entry for Splitwise category '%s'", splitwiseCategoryPath(expense.Category, parent))
+	case !ok:
+		return fmt.Sprintf("category_mapping '%s' (matched by %s) refers to an unknown YNAB category '%s'",
+			m.label(), by, categoryRef{ID: m.YnabId, Name: m.YnabName})
+	case m.YnabName != "":
+		return fmt.Sprintf("category_mapping '%s' (matched by %s) names YNAB category '%s', which has ID '%s'",
+			m.label(), by, m.YnabName, categoryID)
+	default:
+		return fmt.Sprintf("category_mapping '%s' (matched by %s) refers to YNAB category ID '%s'", m.label(), by, categoryID)
+	}
+}
+
+// splitwiseCategoryPath returns the path of a category, or just its name if
+// its parent is unknown.
+func splitwiseCategoryPath(category splitwise.Category, parent *splitwise.Category) string {
+	if parent == nil {
+		return category.Name
+	}
+	return parent.Name + splitwiseCategoryPathSep + category.Name
+}
+
+func (cm CategoryMapping) categoryRefs() []categoryRef {
+	var refs []categoryRef
+	for _, m := range cm {
+		if m.YnabId != "" || m.YnabName != "" {
+			refs = append(refs, categoryRef{
+				ID:     m.YnabId,
+				Name:   m.YnabName,
+				Source: fmt.Sprintf("category_mapping '%s'", m.label()),
+			})
+		}
+	}
+	return refs
+}
+
+// resolveCategories sets the YNAB category ID of every entry which only names
+// its category.
+func (cm CategoryMapping) resolveCategories(resolve func(string) (string, error)) error {
+	for key, m := range cm {
+		if m.YnabId != "" || m.YnabName == "" {
+			continue
+		}
+		id, err := resolve(m.YnabName)
+		if err != nil {
+			return fmt.Errorf("category_mapping '%s': %s", m.label(), err)
+		}
+		m.YnabId = id
+		cm[key] = m
+	}
+	return nil
+}
+
 func (cm *CategoryMapping) UnmarshalJSON(data []byte) error {
 	m := make(map[string]CategoryMappingEntry)
 	var entries []CategoryMappingEntry
@@ -85,46 +255,143 @@ func (cm *CategoryMapping) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	for _, e := range entries {
-		if _, ok := m[e.Name]; ok {
-			return fmt.Errorf("duplicate mapping entry with name '%s'", e.Name)
+		if e.ID == 0 && e.Name == "" {
+			return fmt.Errorf("mapping entries need either an id or a name")
+		}
+		if _, ok := m[e.key()]; ok {
+			return fmt.Errorf("duplicate mapping entry for '%s'", e.label())
 		}
-		m[e.Name] = e
+		m[e.key()] = e
 	}
 	*cm = m
 	return nil
 }
 
 func (cm CategoryMapping) Add(entry CategoryMappingEntry) {
-	cm[entry.Name] = entry
+	cm[entry.key()] = entry
 }
 
 type CategoryMappingEntry struct {
+	// ID is the ID of the Splitwise category. If set, Name is only used to
+	// describe the entry.
+	ID int `json:"id,omitempty"`
+	// Name is the name of the Splitwise category, or its path such as
+	// "Utilities / Electricity". The name of a parent category also applies
+	// to its subcategories which have no entry of their own.
 	Name     string `json:"name"`
 	YnabName string `json:"ynab_name"`
 	YnabId   string `json:"ynab_id"`
 }
 
-func (options *SplitwiseOptions) newSplitwiseClient(ctx context.Context) *splitwise.Client {
+func (e CategoryMappingEntry) key() string {
+	if e.ID != 0 {
+		return categoryIDKey(e.ID)
+	}
+	return e.Name
+}
+
+// label describes the entry in messages.
+func (e CategoryMappingEntry) label() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return categoryIDKey(e.ID)
+}
+
+// validate renders the templates with a sample record, so that mistakes such
+// as unknown fields are found before any expense is imported.
+func (options *SplitwiseOptions) validate() error {
+	templates := []struct {
+		key      string
+		template *Template
+	}{
+		{"memo_template", options.MemoTemplate},
+		{"payee_template", options.PayeeTemplate},
+	}
+	for _, t := range templates {
+		if t.template == nil {
+			continue
+		}
+		if _, err := t.template.render(sampleSplitwiseRecord, ynabMemoMaxLength); err != nil {
+			return keyError(t.key, err)
+		}
+	}
+	return nil
+}
+
+func (options *SplitwiseOptions) categoryRefs() []categoryRef {
+	refs := options.CategoryMapping.categoryRefs()
+	if options.DefaultYnabId != "" || options.DefaultYnabName != "" {
+		refs = append(refs, categoryRef{
+			ID:     options.DefaultYnabId,
+			Name:   options.DefaultYnabName,
+			Source: "default category",
+		})
+	}
+	return refs
+}
+
+func (options *SplitwiseOptions) resolveCategories(resolve func(string) (string, error)) error {
+	if err := options.CategoryMapping.resolveCategories(resolve); err != nil {
+		return err
+	}
+	if options.DefaultYnabId == "" && options.DefaultYnabName != "" {
+		id, err := resolve(options.DefaultYnabName)
+		if err != nil {
+			return fmt.Errorf("default category: %s", err)
+		}
+		options.DefaultYnabId = id
+	}
+	return nil
+}
+
+const defaultSplitwiseRedirectURL = "http://localhost:4000/auth_redirect"
+
+func (options *SplitwiseOptions) oauthConfig() *oauth2.Config {
+	redirectURL := options.RedirectURL
+	if redirectURL == "" {
+		redirectURL = defaultSplitwiseRedirectURL
+	}
+	return &oauth2.Config{
+		ClientID:     options.ClientKey,
+		ClientSecret: options.ClientSecret,
+		Endpoint:     swEndpoint.Endpoint,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func (options *SplitwiseOptions) tokenStore(ctx context.Context) (TokenStore, error) {
+	return newTokenStore(ctx, options.TokenCache)
+}
+
+func (options *SplitwiseOptions) newSplitwiseClient(ctx context.Context) (*splitwise.Client, error) {
+	store, err := options.tokenStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := options.oauthConfig()
 	httpClient := oauth2.NewClient(ctx, &CachingTokenSource{
-		TokenSource: &LocalServerTokenSource{
-			Config: oauth2.Config{
-				ClientID:     options.ClientKey,
-				ClientSecret: options.ClientSecret,
-				Endpoint:     swEndpoint.Endpoint,
-				RedirectURL:  "http://localhost:4000/auth_redirect",
-			},
+		Name:   "splitwise",
+		Store:  store,
+		Config: config,
+		Authorize: &LocalServerTokenSource{
+			Config:  *config,
+			Timeout: defaultAuthTimeout,
 		},
-		Path: options.TokenCache,
+		NonInteractive: !isTerminal(os.Stdin),
 	})
 	return &splitwise.Client{
 		HTTPClient: &LoggingHTTPClient{
 			Client: httpClient,
 		},
-	}
+	}, nil
 }
 
 func (options *SplitwiseOptions) NewProvider(ctx context.Context) (TransactionProvider, error) {
-	client := options.newSplitwiseClient(ctx)
+	client, err := options.newSplitwiseClient(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var userID int
 	if options.UserID == nil {
@@ -158,21 +425,36 @@ func (options *SplitwiseOptions) NewProvider(ctx context.Context) (TransactionPr
 	return &SplitwiseTransactionProvider{
 		userID:          userID,
 		categoryMapping: options.CategoryMapping,
+		defaultCategory: options.DefaultYnabId,
 		client:          client,
+		memoTemplate:    options.MemoTemplate,
+		payeeTemplate:   options.PayeeTemplate,
+		amountStrategy:  options.AmountStrategy,
 	}, nil
 }
 
-func (sts *SplitwiseTransactionProvider) Transactions(ctx context.Context, ynabInfo YnabInfo) ([]ynab.Transaction, error) {
+// splitwiseExpensesPageSize is the number of expenses requested at a time.
+const splitwiseExpensesPageSize = 100
+
+func (sts *SplitwiseTransactionProvider) Transactions(ctx context.Context, ynabInfo YnabInfo) ([]SourceTransaction, error) {
 	log.Info().
 		Int("user", sts.userID).
 		Msg("Splitwise Transactions")
-	// Get all splitwise transactions since this date
-	// Go up to one week before hint
-	datedAfter := ynabInfo.LastUpdateHint.AddDate(0, 0, -7)
 	req := splitwise.GetExpensesRequest{
-		DatedAfter: &datedAfter,
+		Limit: splitwiseExpensesPageSize,
 	}
-	var transactions []ynab.Transaction
+	if !ynabInfo.Since.IsZero() {
+		req.DatedAfter = &ynabInfo.Since
+	} else {
+		// Get all splitwise transactions since this date
+		// Go up to one week before hint
+		datedAfter := ynabInfo.LastUpdateHint.AddDate(0, 0, -7)
+		req.DatedAfter = &datedAfter
+	}
+	if !ynabInfo.Until.IsZero() {
+		req.DatedBefore = &ynabInfo.Until
+	}
+	var transactions []SourceTransaction
 	for {
 		expenses, err := sts.client.GetExpenses(ctx, &req)
 		if err != nil {
@@ -182,52 +464,22 @@ func (sts *SplitwiseTransactionProvider) Transactions(ctx context.Context, ynabI
 			if e.DeletedAt != nil {
 				continue
 			}
-			user, rest := partitionUsers(e.Users, sts.userID)
-			if len(rest) > 1 {
-				return nil, fmt.Errorf("not implemented: multi-user transactions")
-			}
-			log.Debug().
-				Str("expense", fmt.Sprintf("%+v", e)).
-				Dict("user", zerolog.Dict().
-					Str("NetBalance", user.NetBalance).
-					Str("OwedShare", user.OwedShare).
-					Str("PaidShare", user.PaidShare).
-					Int("UserId", user.UserID).
-					Str("FirstName", user.User.FirstName).
-					Str("LastName", user.User.LastName),
-				).
-				Msg("expense")
-
-			net, err := netBalanceToMilliUnits(user.NetBalance)
+			source, err := sts.sourceRecord(ctx, e)
 			if err != nil {
 				return nil, err
 			}
-
-			importId := strconv.Itoa(e.ID)
-			transaction := ynab.Transaction{
-				Amount:    net,
-				PayeeName: rest[0].User.FirstName,
-				Memo:      e.Description,
-				Approved:  false,
-				Date:      ynab.Date(e.CreatedAt.In(time.UTC)),
-				ImportId:  &importId,
+			if ynabInfo.Filter != nil && ynabInfo.Filter(source) != "" {
+				continue
 			}
-			categoryId, ok := sts.categorize(ynabInfo.Categories, e)
-			if ok {
-				log.Debug().
-					Int("splitwise.category.id", e.Category.ID).
-					Str("splitwise.category.Name", e.Category.Name).
-					Str("ynab.category.id", categoryId).
-					Msg("mapping found")
-				transaction.CategoryId = &categoryId
-			} else {
-				log.Debug().
-					Int("splitwise.category.id", e.Category.ID).
-					Str("splitwise.category.Name", e.Category.Name).
-					Msg("no mapping found for splitwise category")
+			t, err := sts.convert(ctx, ynabInfo, e, source, nil)
+			if errors.Is(err, errNothingToImport) {
+				log.Debug().Err(err).Msg("skipping expense")
+				continue
 			}
-
-			transactions = append(transactions, transaction)
+			if err != nil {
+				return nil, err
+			}
+			transactions = append(transactions, t)
 		}
 		if len(expenses) == 0 {
 			break
@@ -236,11 +488,278 @@ func (sts *SplitwiseTransactionProvider) Transactions(ctx context.Context, ynabI
 	return transactions, nil
 }
 
+// Explain fetches a single expense and converts it as Transactions would.
+func (sts *SplitwiseTransactionProvider) Explain(ctx context.Context, ynabInfo YnabInfo, id string, ex *explanation) (SourceTransaction, error) {
+	expenseID, err := strconv.Atoi(id)
+	if err != nil {
+		return SourceTransaction{}, fmt.Errorf("invalid expense ID '%s'", id)
+	}
+	e, err := sts.client.GetExpense(ctx, expenseID)
+	if err != nil {
+		return SourceTransaction{}, fmt.Errorf("get_expense: %s", err)
+	}
+	ex.add("expense", "'%s' on %s costing %s, category '%s' (%d)", e.Description, e.CreatedAt.Format("2006-01-02"),
+		strings.TrimSpace(e.Cost+" "+e.CurrencyCode), e.Category.Name, e.Category.ID)
+	if e.DeletedAt != nil {
+		return SourceTransaction{}, fmt.Errorf("expense %d was deleted on %s and is not imported",
+			e.ID, e.DeletedAt.Format("2006-01-02"))
+	}
+	source, err := sts.sourceRecord(ctx, *e)
+	if err != nil {
+		return SourceTransaction{}, err
+	}
+	return sts.convert(ctx, ynabInfo, *e, source, ex)
+}
+
+// sourceRecord describes an expense before it's converted, so that it can be
+// filtered out even if it can't be converted. The friend is only set for
+// expenses shared with exactly one other user.
+func (sts *SplitwiseTransactionProvider) sourceRecord(ctx context.Context, e splitwise.Expense) (SourceRecord, error) {
+	group, err := sts.groupName(ctx, e.GroupID)
+	if err != nil {
+		return SourceRecord{}, err
+	}
+	parent, err := sts.parentCategory(ctx, e.Category.ID)
+	if err != nil {
+		return SourceRecord{}, err
+	}
+	source := SourceRecord{
+		ID:          strconv.Itoa(e.ID),
+		Description: e.Description,
+		Group:       group,
+		Category:    e.Category.Name,
+	}
+	if e.Category.ID != 0 {
+		source.CategoryKey = categoryIDKey(e.Category.ID)
+	}
+	if parent != nil {
+		source.ParentCategory = parent.Name
+	}
+	if _, rest := partitionUsers(e.Users, sts.userID); len(rest) == 1 {
+		source.Friend = strings.TrimSpace(rest[0].User.FirstName + " " + rest[0].User.LastName)
+	}
+	return source, nil
+}
+
+// convert turns an expense into a transaction from the record describing it,
+// adding each step to ex if it isn't nil.
+func (sts *SplitwiseTransactionProvider) convert(ctx context.Context, ynabInfo YnabInfo, e splitwise.Expense, source SourceRecord, ex *explanation) (SourceTransaction, error) {
+	user, rest := partitionUsers(e.Users, sts.userID)
+	ex.add("users", "user %d is %s, %s", sts.userID, describeExpenseUser(user), describeOtherUsers(rest))
+	switch {
+	case len(rest) > 1:
+		return SourceTransaction{}, fmt.Errorf("not implemented: multi-user transactions")
+	case len(rest) == 0:
+		return SourceTransaction{}, fmt.Errorf("expense %d is not shared with anyone", e.ID)
+	}
+	log.Debug().
+		Str("expense", fmt.Sprintf("%+v", e)).
+		Dict("user", zerolog.Dict().
+			Str("NetBalance", user.NetBalance).
+			Str("OwedShare", user.OwedShare).
+			Str("PaidShare", user.PaidShare).
+			Int("UserId", user.UserID).
+			Str("FirstName", user.User.FirstName).
+			Str("LastName", user.User.LastName),
+		).
+		Msg("expense")
+
+	amount, err := sts.amountStrategy.amount(user)
+	if err != nil {
+		return SourceTransaction{}, fmt.Errorf("expense %d: %s", e.ID, err)
+	}
+	ex.add("amount", "%s", amount.description)
+	if amount.Amount == 0 && sts.amountStrategy.skipsZero() {
+		return SourceTransaction{}, fmt.Errorf("expense %d: %w", e.ID, errNothingToImport)
+	}
+
+	importId := strconv.Itoa(e.ID)
+	transaction := ynab.Transaction{
+		Amount:          amount.Amount,
+		Subtransactions: amount.Subtransactions,
+		PayeeName:       truncate(rest[0].User.FirstName, ynabPayeeNameMaxLength),
+		Memo:            truncate(e.Description, ynabMemoMaxLength),
+		Approved:        false,
+		Date:            ynab.Date(e.CreatedAt.In(time.UTC)),
+		ImportId:        &importId,
+	}
+	parent, err := sts.parentCategory(ctx, e.Category.ID)
+	if err != nil {
+		return SourceTransaction{}, err
+	}
+	categoryId, ok := sts.categorize(ynabInfo.Categories, e, parent)
+	if ok {
+		log.Debug().
+			Int("splitwise.category.id", e.Category.ID).
+			Str("splitwise.category.Name", e.Category.Name).
+			Str("ynab.category.id", categoryId).
+			Msg("mapping found")
+		transaction.CategoryId = &categoryId
+	} else {
+		log.Debug().
+			Int("splitwise.category.id", e.Category.ID).
+			Str("splitwise.category.Name", e.Category.Name).
+			Msg("no mapping found for splitwise category")
+	}
+	ex.add("category", "%s", sts.categoryMapping.describe(e, parent, categoryId, ok))
+	if amount.uncategorized {
+		transaction.CategoryId, ok = nil, true
+		ex.add("category", "none of it is your share, so it is left uncategorized")
+	}
+	if !ok {
+		path := splitwiseCategoryPath(e.Category, parent)
+		if ex == nil {
+			if m, _, found := sts.categoryMapping.match(e.Category, parent); found {
+				if sts.broken == nil {
+					sts.broken = make(map[string]int)
+				}
+				sts.broken[m.label()]++
+			} else {
+				if sts.unmapped == nil {
+					sts.unmapped = make(map[string]int)
+				}
+				sts.unmapped[fmt.Sprintf("%s (%d)", path, e.Category.ID)]++
+			}
+		}
+		if sts.defaultCategory != "" {
+			defaultCategory := sts.defaultCategory
+			transaction.CategoryId = &defaultCategory
+			ex.add("category", "using the default category '%s'", ynabInfo.Categories.Name(&defaultCategory))
+		} else {
+			ex.add("category", "no default category is configured, so it is uncategorized")
+		}
+	}
+
+	if source.Group != "" {
+		ex.add("group", "'%s' (%d)", source.Group, *e.GroupID)
+	}
+	record := splitwiseRecord{
+		Description:    e.Description,
+		Group:          source.Group,
+		Category:       e.Category.Name,
+		ParentCategory: source.ParentCategory,
+		Friend:         rest[0].User.FirstName,
+		Currency:       e.CurrencyCode,
+		Cost:           e.Cost,
+		CommentsCount:  e.CommentsCount,
+		Date:           e.CreatedAt.In(time.UTC),
+		Expense:        e,
+	}
+	for _, u := range e.Users {
+		record.Participants = append(record.Participants, strings.TrimSpace(u.User.FirstName+" "+u.User.LastName))
+	}
+	if err := sts.applyTemplates(&transaction, record, ex); err != nil {
+		return SourceTransaction{}, err
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return SourceTransaction{}, err
+	}
+	source.Unmapped = !ok
+	source.Raw = raw
+	return SourceTransaction{Transaction: transaction, Source: source}, nil
+}
+
+// applyTemplates renders the memo and payee of a transaction from the
+// templates which are configured.
+func (sts *SplitwiseTransactionProvider) applyTemplates(t *ynab.Transaction, record splitwiseRecord, ex *explanation) error {
+	if sts.memoTemplate != nil {
+		memo, err := sts.memoTemplate.render(record, ynabMemoMaxLength)
+		if err != nil {
+			return fmt.Errorf("expense %d: memo_template: %s", record.Expense.ID, err)
+		}
+		t.Memo = memo
+		ex.add("memo", "memo_template rendered '%s'", memo)
+	}
+	if sts.payeeTemplate != nil {
+		payee, err := sts.payeeTemplate.render(record, ynabPayeeNameMaxLength)
+		if err != nil {
+			return fmt.Errorf("expense %d: payee_template: %s", record.Expense.ID, err)
+		}
+		t.PayeeName = payee
+		ex.add("payee", "payee_template rendered '%s'", payee)
+	}
+	return nil
+}
+
+func describeExpenseUser(u splitwise.ExpenseUser) string {
+	name := strings.TrimSpace(u.User.FirstName + " " + u.User.LastName)
+	return fmt.Sprintf("%s: paid %s, owes %s, net balance %s", name, u.PaidShare, u.OwedShare, u.NetBalance)
+}
+
+func describeOtherUsers(users []splitwise.ExpenseUser) string {
+	if len(users) == 0 {
+		return "no other users"
+	}
+	described := make([]string, len(users))
+	for i, u := range users {
+		described[i] = describeExpenseUser(u)
+	}
+	return "other users are " + strings.Join(described, "; ")
+}
+
+// groupName returns the name of the group with the given ID, or an empty
+// string for expenses outside of any group.
+func (sts *SplitwiseTransactionProvider) groupName(ctx context.Context, id *int) (string, error) {
+	if id == nil || *id == 0 {
+		return "", nil
+	}
+	if sts.groupNames == nil {
+		groups, err := sts.client.GetGroups(ctx)
+		if err != nil {
+			return "", fmt.Errorf("get_groups: %s", err)
+		}
+		sts.groupNames = make(map[int]string, len(groups))
+		for _, g := range groups {
+			sts.groupNames[g.ID] = g.Name
+		}
+	}
+	return sts.groupNames[*id], nil
+}
+
+// parentCategory returns the parent of the category with the given ID, or nil
+// if it has none.
+func (sts *SplitwiseTransactionProvider) parentCategory(ctx context.Context, id int) (*splitwise.Category, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	if sts.categoryParents == nil {
+		res, err := sts.client.GetCategories(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get_categories: %s", err)
+		}
+		sts.categoryParents = make(map[int]splitwise.Category)
+		for _, c := range res.Categories {
+			for _, sub := range c.Subcategories {
+				sts.categoryParents[sub.ID] = splitwise.Category{ID: c.ID, Name: c.Name}
+			}
+		}
+	}
+	if parent, ok := sts.categoryParents[id]; ok {
+		return &parent, nil
+	}
+	return nil, nil
+}
+
+// UnmappedCategories lists the Splitwise categories of the expenses which had
+// no mapping, along with how many there were of each.
+func (sts *SplitwiseTransactionProvider) UnmappedCategories() map[string]int {
+	return sts.unmapped
+}
+
+// BrokenMappings lists the labels of the category_mapping entries which refer
+// to an unknown, hidden or deleted YNAB category, along with how many expenses
+// there were for each.
+func (sts *SplitwiseTransactionProvider) BrokenMappings() map[string]int {
+	return sts.broken
+}
+
 func (sts *SplitwiseTransactionProvider) categorize(
-	ynabCategories []ynab.Category,
+	ynabCategories *CategoryIndex,
 	expense splitwise.Expense,
+	parent *splitwise.Category,
 ) (string, bool) {
-	ynabCatId, ok := sts.categoryMapping.Categorize(ynabCategories, expense)
+	ynabCatId, ok := sts.categoryMapping.Categorize(ynabCategories, expense, parent)
 	if ok {
 		return ynabCatId, true
 	}
@@ -252,9 +771,13 @@ const (
 	milliunitsPerDollar = 100 * milliunitsPerCent
 )
 
-func netBalanceToMilliUnits(owed string) (int, error) {
-	split := strings.Split(owed, ".")
-	if len(split) != 2 {
+// amountToMilliUnits converts an amount such as "-12.5" as given by Splitwise
+// to milliunits.
+func amountToMilliUnits(amount string) (int, error) {
+	// The sign is taken separately since "-0" would lose it.
+	negative := strings.HasPrefix(amount, "-")
+	split := strings.Split(strings.TrimPrefix(amount, "-"), ".")
+	if len(split) != 2 || len(split[1]) == 0 || len(split[1]) > 2 {
 		return 0, fmt.Errorf("invalid value")
 	}
 	dollars, err := strconv.Atoi(split[0])
@@ -265,14 +788,15 @@ func netBalanceToMilliUnits(owed string) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("cents: %s", err)
 	}
-	if dollars < 0 {
-		cents *= -1
-	}
 	// account for a trimmed final zero
 	if len(split[1]) == 1 {
 		cents *= 10
 	}
-	return dollars*milliunitsPerDollar + cents*milliunitsPerCent, nil
+	milliunits := dollars*milliunitsPerDollar + cents*milliunitsPerCent
+	if negative {
+		milliunits = -milliunits
+	}
+	return milliunits, nil
 }
 
 func partitionUsers(users []splitwise.ExpenseUser, userID int) (splitwise.ExpenseUser, []splitwise.ExpenseUser) {
@@ -3,8 +3,10 @@ package main
 import (
 	"budgetbridge/splitwise"
 	"budgetbridge/ynab"
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -18,9 +20,9 @@ func TestExpensesToTransactions(t *testing.T) {
 		expensesResponse: "fixtures/mock_expenses.json",
 	}
 	provider := SplitwiseTransactionProvider{
-		userID,
-		&client,
-		make(map[string]CategoryMappingEntry),
+		userID:          userID,
+		client:          &client,
+		categoryMapping: make(map[string]CategoryMappingEntry),
 	}
 
 	r := require.New(t)
@@ -52,10 +54,43 @@ func TestExpensesToTransactions(t *testing.T) {
 			ImportId:  stringPtr("3"),
 		},
 	}
-	r.Equal(expected, txs)
+	r.Len(txs, len(expected))
+	for i, tx := range txs {
+		r.Equal(expected[i], tx.Transaction)
+		r.Equal(*expected[i].ImportId, tx.Source.ID)
+	}
+	r.Equal("Groceries", txs[0].Source.Category)
+	r.Equal("Apartment", txs[0].Source.Group)
+	r.Equal("", txs[1].Source.Group)
+	r.Equal("Dinner", txs[1].Source.Description)
+}
+
+func TestExpensesDateRange(t *testing.T) {
+	r := require.New(t)
+
+	client := mockClient{
+		expensesResponse: "fixtures/mock_expenses.json",
+	}
+	provider := SplitwiseTransactionProvider{
+		userID:          456,
+		client:          &client,
+		categoryMapping: make(map[string]CategoryMappingEntry),
+	}
+	since := date(2020, 7, 1)
+	until := date(2020, 8, 1)
+	_, err := provider.Transactions(context.Background(), YnabInfo{
+		LastUpdateHint: date(2020, 7, 20),
+		Since:          since,
+		Until:          until,
+	})
+	r.NoError(err)
+	r.Len(client.requests, 2)
+	r.Equal(since, *client.requests[0].DatedAfter)
+	r.Equal(until, *client.requests[0].DatedBefore)
+	r.Equal(3, client.requests[1].Offset, "the next page follows the first")
 }
 
-func TestNetBalanceToMilliunits(t *testing.T) {
+func TestAmountToMilliunits(t *testing.T) {
 	r := require.New(t)
 
 	testcases := []struct {
@@ -79,14 +114,103 @@ func TestNetBalanceToMilliunits(t *testing.T) {
 			balance: "-1.23",
 			units:   -1230,
 		},
+		{
+			balance: "-0.5",
+			units:   -500,
+		},
+		{
+			balance: "-0.05",
+			units:   -50,
+		},
+		{
+			balance: "0.0",
+			units:   0,
+		},
+		{
+			balance: "12",
+			err:     true,
+		},
+		{
+			balance: "1.234",
+			err:     true,
+		},
 	}
 	for _, tc := range testcases {
-		units, err := netBalanceToMilliUnits(tc.balance)
+		units, err := amountToMilliUnits(tc.balance)
+		if tc.err {
+			r.Error(err, tc.balance)
+			continue
+		}
 		r.NoError(err)
-		r.Equal(tc.units, units)
+		r.Equal(tc.units, units, tc.balance)
 	}
 }
 
+func TestAmountStrategies(t *testing.T) {
+	r := require.New(t)
+
+	utilities := "utilities"
+	categories := newCategoryIndex([]ynab.CategoryGroup{{Name: "Bills", Categories: []ynab.Category{
+		{Id: utilities, Name: "Utilities"},
+	}}})
+	for _, tc := range []struct {
+		strategy AmountStrategy
+		// The amounts of the expenses by ID, which are left out if they
+		// come to nothing.
+		amounts map[string]int
+		// The subtransactions of the electric bill, which user 456 paid.
+		split []ynab.SubTransaction
+	}{
+		{"", map[string]int{"1": -75000, "2": -16500, "3": 67020}, nil},
+		{amountNetBalance, map[string]int{"1": -75000, "2": -16500, "3": 67020}, nil},
+		{amountOwedShare, map[string]int{"1": -75000, "2": -16500, "3": -67020}, nil},
+		{amountPaidShare, map[string]int{"3": -134040}, nil},
+		{amountOwedShareOffset, map[string]int{"1": -75000, "2": -16500, "3": 67020}, []ynab.SubTransaction{
+			{Amount: -67020, Memo: "Your share", CategoryId: &utilities},
+			{Amount: 134040, Memo: "Paid by you"},
+		}},
+	} {
+		provider := SplitwiseTransactionProvider{
+			userID:          456,
+			client:          &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+			categoryMapping: CategoryMapping{categoryIDKey(5): {ID: 5, YnabId: utilities}},
+			amountStrategy:  tc.strategy,
+		}
+		txs, err := provider.Transactions(context.Background(), YnabInfo{Categories: categories})
+		r.NoError(err, tc.strategy)
+		amounts := make(map[string]int)
+		for _, t := range txs {
+			tx := t.ynabTransaction()
+			amounts[t.Source.ID] = tx.Amount
+			if t.Source.ID != "3" {
+				continue
+			}
+			r.Equal(tc.split, tx.Subtransactions, tc.strategy)
+			if tc.split == nil {
+				r.Equal(utilities, *tx.CategoryId, tc.strategy)
+			} else {
+				r.Nil(tx.CategoryId, "only the parts of a split are categorized")
+				sum := 0
+				for _, sub := range tx.Subtransactions {
+					sum += sub.Amount
+				}
+				r.Equal(tx.Amount, sum)
+			}
+		}
+		r.Equal(tc.amounts, amounts, tc.strategy)
+	}
+
+	amount, err := amountOwedShareOffset.amount(splitwise.ExpenseUser{NetBalance: "20.0", OwedShare: "0.0", PaidShare: "20.0"})
+	r.NoError(err)
+	r.Equal(20000, amount.Amount)
+	r.Empty(amount.Subtransactions)
+	r.True(amount.uncategorized, "paying for someone else isn't spending")
+
+	var options SplitwiseOptions
+	r.EqualError(json.Unmarshal([]byte(`{"amount_strategy": "gross"}`), &options),
+		"unknown amount_strategy 'gross', expected one of net_balance, owed_share, paid_share, owed_share_offset")
+}
+
 func TestCategoryMapping(t *testing.T) {
 	mapping := make(CategoryMapping)
 	// Both name and ID
@@ -101,7 +225,7 @@ func TestCategoryMapping(t *testing.T) {
 		YnabName: "YnabInternet",
 	})
 
-	ynabCategories := []ynab.Category{
+	ynabCategories := newCategoryIndex([]ynab.CategoryGroup{{Categories: []ynab.Category{
 		{
 			Id:   "1234",
 			Name: "YnabGroceries",
@@ -110,34 +234,170 @@ func TestCategoryMapping(t *testing.T) {
 			Id:   "4567",
 			Name: "YnabInternet",
 		},
-	}
+	}}})
 
 	r := require.New(t)
 	id, _ := mapping.Categorize(ynabCategories, splitwise.Expense{
 		Category: splitwise.Category{
 			Name: "Groceries",
 		},
-	})
+	}, nil)
 	r.Equal(id, "1234")
 
 	id, _ = mapping.Categorize(ynabCategories, splitwise.Expense{
 		Category: splitwise.Category{
 			Name: "Internet",
 		},
-	})
+	}, nil)
 	r.Equal(id, "4567")
 
 	id, ok := mapping.Categorize(ynabCategories, splitwise.Expense{
 		Category: splitwise.Category{
 			Name: "Dining Out",
 		},
-	})
+	}, nil)
 	r.Equal(id, "")
 	r.False(ok)
 }
 
+func TestCategoryMappingHierarchy(t *testing.T) {
+	r := require.New(t)
+
+	var mapping CategoryMapping
+	r.NoError(json.Unmarshal([]byte(`[
+		{"id": 13, "name": "Eating out", "ynab_id": "dining"},
+		{"name": "Food and drink / Groceries", "ynab_id": "groceries"},
+		{"name": "Food and drink", "ynab_id": "food"},
+		{"id": 4, "ynab_id": "utilities"}
+	]`), &mapping))
+	ynabCategories := newCategoryIndex([]ynab.CategoryGroup{{Categories: []ynab.Category{
+		{Id: "dining"}, {Id: "groceries"}, {Id: "food"}, {Id: "utilities"},
+	}}})
+	food := &splitwise.Category{ID: 1, Name: "Food and drink"}
+	utilities := &splitwise.Category{ID: 4, Name: "Utilities"}
+
+	for _, tc := range []struct {
+		category splitwise.Category
+		parent   *splitwise.Category
+		id       string
+		by       string
+	}{
+		{splitwise.Category{ID: 13, Name: "Dining out"}, food, "dining", "ID"},
+		{splitwise.Category{ID: 12, Name: "Groceries"}, food, "groceries", "path"},
+		{splitwise.Category{ID: 14, Name: "Liquor"}, food, "food", "parent name"},
+		{splitwise.Category{ID: 5, Name: "Electricity"}, utilities, "utilities", "parent ID"},
+		{splitwise.Category{ID: 15, Name: "Liquor"}, nil, "", ""},
+	} {
+		id, _ := mapping.Categorize(ynabCategories, splitwise.Expense{Category: tc.category}, tc.parent)
+		r.Equal(tc.id, id, tc.category.Name)
+		_, by, _ := mapping.match(tc.category, tc.parent)
+		r.Equal(tc.by, by, tc.category.Name)
+	}
+
+	r.EqualError(json.Unmarshal([]byte(`[{"id": 4}, {"id": 4, "name": "Utilities"}]`), &mapping),
+		"duplicate mapping entry for 'Utilities'")
+	r.EqualError(json.Unmarshal([]byte(`[{"ynab_id": "food"}]`), &mapping),
+		"mapping entries need either an id or a name")
+}
+
+func TestDefaultCategory(t *testing.T) {
+	r := require.New(t)
+
+	provider := SplitwiseTransactionProvider{
+		userID:          456,
+		client:          &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+		categoryMapping: CategoryMapping{"Groceries": {Name: "Groceries", YnabId: "groceries"}},
+		defaultCategory: "misc",
+	}
+	ynabCategories := newCategoryIndex([]ynab.CategoryGroup{{Categories: []ynab.Category{
+		{Id: "groceries"}, {Id: "misc"},
+	}}})
+	txs, err := provider.Transactions(context.Background(), YnabInfo{Categories: ynabCategories})
+	r.NoError(err)
+	r.Len(txs, 3)
+	r.Equal("groceries", *txs[0].CategoryId)
+	r.Equal("misc", *txs[1].CategoryId)
+	r.Equal("misc", *txs[2].CategoryId)
+	r.Equal(map[string]int{
+		"Food and drink / Dining out (13)": 1,
+		"Utilities / Electricity (5)":      1,
+	}, provider.UnmappedCategories())
+
+	state := &State{Categories: map[string]map[string]string{"": {"#13": "dining"}}}
+	txs = state.apply(txs)
+	r.Equal("dining", *txs[1].CategoryId, "learned categories replace the default")
+	r.False(txs[1].Source.Unmapped)
+
+	var out bytes.Buffer
+	bb := BudgetBridge{
+		providers:    []NamedProvider{{Name: "splitwise", TransactionProvider: &provider}},
+		output:       &out,
+		outputFormat: formatTable,
+	}
+	bb.reportUnmapped()
+	r.Equal(`
+splitwise categories with no mapping:
+  Food and drink / Dining out (13): 1
+  Utilities / Electricity (5): 1
+`, out.String())
+}
+
+func TestBrokenMappings(t *testing.T) {
+	r := require.New(t)
+
+	provider := SplitwiseTransactionProvider{
+		userID: 456,
+		client: &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+		categoryMapping: CategoryMapping{
+			"Groceries":  {Name: "Groceries", YnabId: "groceries"},
+			"Dining out": {Name: "Dining out", YnabId: "deleted"},
+		},
+	}
+	ynabCategories := newCategoryIndex([]ynab.CategoryGroup{{Categories: []ynab.Category{
+		{Id: "groceries"}, {Id: "deleted", Deleted: true},
+	}}})
+	txs, err := provider.Transactions(context.Background(), YnabInfo{Categories: ynabCategories})
+	r.NoError(err)
+	r.Len(txs, 3)
+	r.Nil(txs[1].CategoryId)
+	r.Equal(map[string]int{"Dining out": 1}, provider.BrokenMappings())
+	r.Equal(map[string]int{"Utilities / Electricity (5)": 1}, provider.UnmappedCategories(),
+		"categories with a broken mapping aren't reported as having none")
+}
+
+func TestTemplates(t *testing.T) {
+	r := require.New(t)
+
+	var options SplitwiseOptions
+	r.NoError(json.Unmarshal([]byte(`{
+		"memo_template": "{{.Description}} ({{.Group}}, {{.Currency}} {{.Cost}}, {{.CommentsCount}} comments) {{.Date.Format \"Jan 2\"}}",
+		"payee_template": "{{join .Participants \" & \"}}"
+	}`), &options))
+	provider := SplitwiseTransactionProvider{
+		userID:        456,
+		client:        &mockClient{expensesResponse: "fixtures/mock_expenses.json"},
+		memoTemplate:  options.MemoTemplate,
+		payeeTemplate: options.PayeeTemplate,
+	}
+	txs, err := provider.Transactions(context.Background(), YnabInfo{})
+	r.NoError(err)
+	r.Equal("Groceries (Apartment, USD 150.0, 2 comments) Aug 9", txs[0].Memo)
+	r.Equal("Annie Edison & Jeff Winger", txs[0].PayeeName)
+	r.Equal("Dinner (, USD 31.0, 0 comments) Aug 3", txs[1].Memo)
+
+	r.NoError(json.Unmarshal([]byte(`{"memo_template": "{{.Notes}}"}`), &options))
+	provider.memoTemplate = options.MemoTemplate
+	_, err = provider.Transactions(context.Background(), YnabInfo{})
+	r.Error(err)
+	r.Contains(err.Error(), "expense 1: memo_template: ")
+
+	r.EqualError(json.Unmarshal([]byte(`{"payee_template": "{{.Friend"}`), &options),
+		"template: :1: unclosed action")
+}
+
 type mockClient struct {
 	expensesResponse string
+	requests         []splitwise.GetExpensesRequest
 }
 
 func (c *mockClient) GetCurrentUser() (*splitwise.User, error) {
@@ -145,10 +405,49 @@ func (c *mockClient) GetCurrentUser() (*splitwise.User, error) {
 	return &res, nil
 }
 
+func (c *mockClient) GetGroups(ctx context.Context) ([]splitwise.Group, error) {
+	return []splitwise.Group{{ID: 42, Name: "Apartment"}}, nil
+}
+
+func (c *mockClient) GetCategories(ctx context.Context) (*splitwise.GetCategoriesResponse, error) {
+	return &splitwise.GetCategoriesResponse{Categories: []splitwise.Category{
+		{ID: 1, Name: "Food and drink", Subcategories: []splitwise.Subcategory{
+			{ID: 12, Name: "Groceries"},
+			{ID: 13, Name: "Dining out"},
+		}},
+		{ID: 4, Name: "Utilities", Subcategories: []splitwise.Subcategory{
+			{ID: 5, Name: "Electricity"},
+		}},
+	}}, nil
+}
+
+func (c *mockClient) GetExpense(ctx context.Context, id int) (*splitwise.Expense, error) {
+	expenses, err := c.loadExpenses()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range expenses {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("no expense %d", id)
+}
+
 func (c *mockClient) GetExpenses(ctx context.Context, req *splitwise.GetExpensesRequest) ([]splitwise.Expense, error) {
+	c.requests = append(c.requests, *req)
 	if req.Offset > 0 {
 		return nil, nil
 	}
+	expenses, err := c.loadExpenses()
+	if err != nil {
+		return nil, err
+	}
+	req.Offset = len(expenses)
+	return expenses, nil
+}
+
+func (c *mockClient) loadExpenses() ([]splitwise.Expense, error) {
 	f, err := os.Open(c.expensesResponse)
 	if err != nil {
 		return nil, err
@@ -161,7 +460,6 @@ func (c *mockClient) GetExpenses(ctx context.Context, req *splitwise.GetExpenses
 	if err := decoder.Decode(&res); err != nil {
 		return nil, err
 	}
-	req.Offset = len(res.Expenses)
 	return res.Expenses, nil
 }
 
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const stateFileName = "budgetbridge_state.json"
+
+// State is what the bridge remembers between runs, as decided while reviewing
+// transactions.
+type State struct {
+	path string
+
+	// Ignored holds the IDs of the source records which are never imported,
+	// by provider.
+	Ignored map[string][]string `json:"ignored"`
+	// Categories holds the YNAB category IDs to use for each source category,
+	// by provider and then by the category key of the source records, or
+	// the category name for providers without keys.
+	Categories map[string]map[string]string `json:"categories"`
+}
+
+// loadState reads the state kept in dir, which is empty if it was never saved.
+func loadState(dir string) (*State, error) {
+	state := &State{
+		path:       filepath.Join(dir, stateFileName),
+		Ignored:    make(map[string][]string),
+		Categories: make(map[string]map[string]string),
+	}
+	f, err := os.Open(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *State) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, 0644, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s)
+	})
+}
+
+// ignore marks a source record so that it's never imported.
+func (s *State) ignore(provider, id string) {
+	if !s.isIgnored(provider, id) {
+		s.Ignored[provider] = append(s.Ignored[provider], id)
+	}
+}
+
+func (s *State) isIgnored(provider, id string) bool {
+	if s == nil {
+		return false
+	}
+	for _, ignored := range s.Ignored[provider] {
+		if ignored == id {
+			return true
+		}
+	}
+	return false
+}
+
+// learnCategory remembers the YNAB category to use for a source category.
+func (s *State) learnCategory(provider, source, categoryID string) {
+	if s.Categories[provider] == nil {
+		s.Categories[provider] = make(map[string]string)
+	}
+	s.Categories[provider][source] = categoryID
+}
+
+// category returns the learned YNAB category ID for a source category.
+func (s *State) category(provider, source string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	id, ok := s.Categories[provider][source]
+	return id, ok
+}
+
+// categoryKey returns what the category of a record is remembered by: its
+// category key, or its name if the provider has no keys.
+func (r SourceRecord) categoryKey() string {
+	if r.CategoryKey != "" {
+		return r.CategoryKey
+	}
+	return r.Category
+}
+
+// categoryPath describes the category of a record along with its parent.
+func (r SourceRecord) categoryPath() string {
+	if r.ParentCategory == "" {
+		return r.Category
+	}
+	return r.ParentCategory + splitwiseCategoryPathSep + r.Category
+}
+
+// apply leaves out ignored transactions, and sets learned categories. These
+// were chosen during a review for the exact source category, so they take
+// precedence over the category mappings of the provider.
+func (s *State) apply(transactions []SourceTransaction) []SourceTransaction {
+	if s == nil {
+		return transactions
+	}
+	kept := transactions[:0]
+	for _, t := range transactions {
+		if s.isIgnored(t.Source.Provider, t.Source.ID) {
+			continue
+		}
+		if key := t.Source.categoryKey(); key != "" {
+			if id, ok := s.category(t.Source.Provider, key); ok {
+				t.CategoryId = &id
+				t.Source.Unmapped = false
+			}
+		}
+		kept = append(kept, t)
+	}
+	return kept
+}
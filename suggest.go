@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"budgetbridge/ynab"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultSuggestionHistoryDays = 365
+
+// SuggestionConfig configures the suggestion of categories for transactions
+// which the providers couldn't categorize.
+type SuggestionConfig struct {
+	// Enabled learns from the categories of the transactions imported
+	// before, as they are in YNAB now.
+	Enabled bool `json:"enabled"`
+	// HistoryDays is how far back imported transactions are learned from.
+	HistoryDays int `json:"history_days"`
+	// AutoApply is the confidence between 0 and 1 at or above which a
+	// suggestion is used as the category. Suggestions are only shown in dry
+	// runs and reviews unless it's set.
+	AutoApply float64 `json:"auto_apply"`
+}
+
+func (sc *SuggestionConfig) UnmarshalJSON(data []byte) error {
+	type raw SuggestionConfig
+	r := raw(*sc)
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	switch {
+	case r.AutoApply < 0 || r.AutoApply > 1:
+		return fmt.Errorf("auto_apply must be between 0 and 1")
+	case r.HistoryDays < 0:
+		return fmt.Errorf("history_days must not be negative")
+	}
+	*sc = SuggestionConfig(r)
+	return nil
+}
+
+// A Suggestion is the category a transaction most likely belongs in, going by
+// the transactions imported before it.
+type Suggestion struct {
+	CategoryID string `json:"category_id"`
+	// Confidence is between 0 and 1, growing with the number of earlier
+	// transactions which agree.
+	Confidence float64 `json:"confidence"`
+	// Applied is set when the suggestion was used as the category.
+	Applied bool `json:"applied,omitempty"`
+}
+
+func (s Suggestion) String() string {
+	return fmt.Sprintf("%.0f%%", s.Confidence*100)
+}
+
+// The weight of each kind of feature when scoring a suggestion. The category
+// within the provider is the strongest evidence, while each word of the
+// description adds a little.
+const (
+	categoryFeatureWeight = 3
+	payeeFeatureWeight    = 1
+	tokenFeatureWeight    = 1
+)
+
+// A suggestionFeature is something known about a transaction before it's
+// categorized.
+type suggestionFeature struct {
+	key    string
+	weight float64
+}
+
+// suggestionFeatures returns the features of a transaction: the category of
+// its source record, its payee, and the words of its description.
+func suggestionFeatures(provider, category, payee, description string) []suggestionFeature {
+	var features []suggestionFeature
+	if category != "" {
+		features = append(features, suggestionFeature{"category:" + provider + ":" + strings.ToLower(category), categoryFeatureWeight})
+	}
+	if payee != "" {
+		features = append(features, suggestionFeature{"payee:" + strings.ToLower(payee), payeeFeatureWeight})
+	}
+	seen := make(map[string]bool)
+	for _, token := range descriptionTokens(description) {
+		if !seen[token] {
+			seen[token] = true
+			features = append(features, suggestionFeature{"token:" + token, tokenFeatureWeight})
+		}
+	}
+	return features
+}
+
+// descriptionTokens splits a description into lower case words, leaving out
+// numbers and words too short to say much.
+func descriptionTokens(description string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 3 || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// A suggester suggests categories for transactions from how often each of
+// their features was seen with each category.
+type suggester struct {
+	// counts holds, for each feature, how many transactions with it were in
+	// each category.
+	counts map[string]map[string]int
+	totals map[string]int
+	// learned is the number of transactions learned from.
+	learned int
+	// autoApply is the confidence at or above which a suggestion is used,
+	// or 0 if never.
+	autoApply float64
+}
+
+func newSuggester(autoApply float64) *suggester {
+	return &suggester{
+		counts:    make(map[string]map[string]int),
+		totals:    make(map[string]int),
+		autoApply: autoApply,
+	}
+}
+
+// learn records that a transaction with the features was in the category.
+func (s *suggester) learn(features []suggestionFeature, categoryID string) {
+	for _, f := range features {
+		if s.counts[f.key] == nil {
+			s.counts[f.key] = make(map[string]int)
+		}
+		s.counts[f.key][categoryID]++
+		s.totals[f.key]++
+	}
+	s.learned++
+}
+
+// suggest returns the most likely category for a transaction with the
+// features, if any of them were seen before.
+//
+// Each feature which was seen votes for the categories it was seen with in
+// proportion to how often, with one extra unseen observation so that a
+// feature seen only once counts for half. The confidence is the share of the
+// votes which the chosen category could have received.
+func (s *suggester) suggest(features []suggestionFeature) (Suggestion, bool) {
+	scores := make(map[string]float64)
+	var possible float64
+	for _, f := range features {
+		total := s.totals[f.key]
+		if total == 0 {
+			continue
+		}
+		possible += f.weight
+		for categoryID, n := range s.counts[f.key] {
+			scores[categoryID] += f.weight * float64(n) / float64(total+1)
+		}
+	}
+	if possible == 0 {
+		return Suggestion{}, false
+	}
+	// Ties go to the first category by ID so that suggestions are stable.
+	categories := make([]string, 0, len(scores))
+	for categoryID := range scores {
+		categories = append(categories, categoryID)
+	}
+	sort.Strings(categories)
+	var best Suggestion
+	for _, categoryID := range categories {
+		if confidence := scores[categoryID] / possible; confidence > best.Confidence {
+			best = Suggestion{CategoryID: categoryID, Confidence: confidence}
+		}
+	}
+	return best, true
+}
+
+// apply suggests a category for each transaction without one or with only a
+// default, using the suggestion as the category if its confidence is high
+// enough.
+func (s *suggester) apply(transactions []SourceTransaction) []SourceTransaction {
+	if s == nil {
+		return transactions
+	}
+	for i := range transactions {
+		s.applyTo(&transactions[i])
+	}
+	return transactions
+}
+
+func (s *suggester) applyTo(t *SourceTransaction) {
+	if t.CategoryId != nil && !t.Source.Unmapped {
+		return
+	}
+	suggestion, ok := s.suggest(suggestionFeatures(t.Source.Provider, t.Source.Category, t.PayeeName, t.Source.Description))
+	if !ok {
+		return
+	}
+	if s.autoApply > 0 && suggestion.Confidence >= s.autoApply {
+		id := suggestion.CategoryID
+		t.CategoryId = &id
+		t.Source.Unmapped = false
+		suggestion.Applied = true
+	}
+	t.Suggestion = &suggestion
+}
+
+// loadSuggester learns from the transactions previously imported into the
+// accounts of the providers, as identified by their import IDs. The source
+// records they were created from are found in the journal, where they were
+// recorded; otherwise only their memo and payee are learned from.
+//
+// It returns nil if suggestions aren't enabled.
+func (bb BudgetBridge) loadSuggester(ctx context.Context) (*suggester, error) {
+	if !bb.suggestions.Enabled {
+		return nil, nil
+	}
+	type key struct{ account, importID string }
+	sources := make(map[key]SourceRecord)
+	if bb.journal != nil {
+		runs, err := bb.journal.Runs()
+		if err != nil {
+			return nil, fmt.Errorf("read journal: %s", err)
+		}
+		for _, run := range runs {
+			if run.Undone != nil {
+				continue
+			}
+			for _, t := range run.Created {
+				if t.ImportID != "" {
+					sources[key{t.AccountID, t.ImportID}] = t.Source
+				}
+			}
+		}
+	}
+
+	s := newSuggester(bb.suggestions.AutoApply)
+	since := time.Now().AddDate(0, 0, -bb.suggestions.HistoryDays)
+	seen := make(map[string]bool)
+	for _, provider := range bb.providers {
+		if seen[provider.AccountID] {
+			continue
+		}
+		seen[provider.AccountID] = true
+		res, err := bb.ynabClient.Transactions(ctx, ynab.TransactionsRequest{
+			BudgetID:  bb.BudgetID,
+			AccountID: provider.AccountID,
+			SinceDate: since,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("fetch imported transactions: %s", err)
+		}
+		for _, t := range res.Transactions {
+			if t.Deleted || t.ImportId == nil || t.CategoryId == nil {
+				continue
+			}
+			// Categories which can no longer be used aren't suggested.
+			if _, ok := bb.categories.ByID(*t.CategoryId); !ok {
+				continue
+			}
+			source, ok := sources[key{provider.AccountID, *t.ImportId}]
+			if !ok {
+				source = SourceRecord{Provider: provider.Name, Description: t.Memo}
+			}
+			s.learn(suggestionFeatures(source.Provider, source.Category, t.PayeeName, source.Description), *t.CategoryId)
+		}
+	}
+	log.Debug().Int("transactions", s.learned).Msg("learned category suggestions")
+	return s, nil
+}
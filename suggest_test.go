@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescriptionTokens(t *testing.T) {
+	require.Equal(t, []string{"dinner", "joe", "café"}, descriptionTokens("Dinner at Joe's Café, 2 x $15"))
+}
+
+func TestSuggester(t *testing.T) {
+	r := require.New(t)
+
+	s := newSuggester(0)
+	r.NotNil(s)
+	_, ok := s.suggest(suggestionFeatures("splitwise", "Dining out", "Annie", "Dinner"))
+	r.False(ok, "nothing was learned yet")
+
+	s.learn(suggestionFeatures("splitwise", "Dining out", "Annie", "Dinner at Joe's"), "dining")
+	s.learn(suggestionFeatures("splitwise", "Dining out", "Annie", "Dinner with friends"), "dining")
+	s.learn(suggestionFeatures("splitwise", "Groceries", "Annie", "Weekly groceries"), "groceries")
+
+	suggestion, ok := s.suggest(suggestionFeatures("splitwise", "dining out", "Bob", "Dinner"))
+	r.True(ok)
+	r.Equal("dining", suggestion.CategoryID)
+	// The category votes 3 * 2/3 and the description 1 * 2/3 out of 4, since
+	// the payee was never seen.
+	r.InDelta(2.0/3, suggestion.Confidence, 1e-9)
+	r.Equal("67%", suggestion.String())
+
+	_, ok = s.suggest(suggestionFeatures("other", "Dining out", "Bob", "Brunch"))
+	r.False(ok, "categories are learned per provider")
+}
+
+func TestLoadSuggester(t *testing.T) {
+	r := require.New(t)
+
+	journal := newJournal(t.TempDir())
+	r.NoError(journal.Record(JournalRun{ID: "1", Created: []JournalTransaction{
+		{AccountID: "account", ImportID: "1", Source: SourceRecord{Provider: "splitwise", Category: "Dining out", Description: "Dinner"}},
+		{AccountID: "account", ImportID: "4", Source: SourceRecord{Provider: "splitwise", Category: "Dining out", Description: "Lunch"}},
+	}}))
+	tx := func(importID, categoryID, payee, memo string) ynab.Transaction {
+		t := ynab.Transaction{CategoryId: &categoryID, PayeeName: payee, Memo: memo}
+		if importID != "" {
+			t.ImportId = &importID
+		}
+		return t
+	}
+	client := &fakeYNAB{transactions: ynab.TransactionsResponse{Transactions: []ynab.Transaction{
+		// The memo was changed after it was imported.
+		tx("1", "dining", "Annie", "Date night"),
+		tx("2", "groceries", "Bob", "Weekly groceries"),
+		tx("", "dining", "Bob", "Entered by hand"),
+		tx("3", "old", "Bob", "Hidden category"),
+		tx("4", "dining", "Annie", "Lunch"),
+	}}}
+	bb := BudgetBridge{
+		BudgetID:   "budget",
+		ynabClient: client,
+		providers: []NamedProvider{
+			{Name: "splitwise", AccountID: "account"},
+		},
+		categories: newCategoryIndex([]ynab.CategoryGroup{{Name: "Everyday", Categories: []ynab.Category{
+			{Id: "dining", Name: "Dining Out"},
+			{Id: "groceries", Name: "Groceries"},
+			{Id: "old", Name: "Old", Hidden: true},
+		}}}),
+		journal: journal,
+	}
+	s, err := bb.loadSuggester(context.Background())
+	r.NoError(err)
+	r.Nil(s, "suggestions are opt-in")
+
+	bb.suggestions = SuggestionConfig{Enabled: true, HistoryDays: 30, AutoApply: 0.6}
+	s, err = bb.loadSuggester(context.Background())
+	r.NoError(err)
+	r.Equal(3, s.learned)
+
+	misc, mapped := "misc", "groceries"
+	txs := s.apply([]SourceTransaction{
+		{
+			Transaction: ynab.Transaction{PayeeName: "Annie", CategoryId: &misc},
+			Source:      SourceRecord{Provider: "splitwise", Category: "Dining out", Description: "Dinner", Unmapped: true},
+		},
+		{
+			Transaction: ynab.Transaction{PayeeName: "Bob"},
+			Source:      SourceRecord{Provider: "splitwise", Category: "General", Description: "groceries for the week"},
+		},
+		{
+			Transaction: ynab.Transaction{PayeeName: "Annie", CategoryId: &mapped},
+			Source:      SourceRecord{Provider: "splitwise", Category: "Dining out", Description: "Dinner"},
+		},
+		{
+			Transaction: ynab.Transaction{PayeeName: "Zed"},
+			Source:      SourceRecord{Provider: "splitwise", Description: "xyz"},
+		},
+	})
+
+	r.Equal("dining", txs[0].Suggestion.CategoryID)
+	// The category and the payee vote 3 * 2/3 and 1 * 2/3, and the
+	// description, learned from the journal, 1 * 1/2 out of 5.
+	r.InDelta(19.0/30, txs[0].Suggestion.Confidence, 1e-9)
+	r.True(txs[0].Suggestion.Applied)
+	r.Equal("dining", *txs[0].CategoryId)
+	r.False(txs[0].Source.Unmapped)
+
+	r.Equal("groceries", txs[1].Suggestion.CategoryID)
+	r.InDelta(0.5, txs[1].Suggestion.Confidence, 1e-9)
+	r.False(txs[1].Suggestion.Applied, "below the auto_apply threshold")
+	r.Nil(txs[1].CategoryId)
+
+	r.Nil(txs[2].Suggestion, "mapped transactions keep their category")
+	r.Nil(txs[3].Suggestion)
+}
+
+func TestSuggestionConfigValidate(t *testing.T) {
+	var config SuggestionConfig
+	require.EqualError(t, json.Unmarshal([]byte(`{"auto_apply": 1.5}`), &config), "auto_apply must be between 0 and 1")
+}
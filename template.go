@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// The longest memo and payee name YNAB accepts, in characters.
+const (
+	ynabMemoMaxLength      = 200
+	ynabPayeeNameMaxLength = 50
+)
+
+// templateFuncs are the functions available to templates beyond the
+// builtins of text/template.
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// Template is a text/template written as a string, which renders a field of a
+// transaction from its source record.
+type Template struct {
+	*template.Template
+	source string
+}
+
+func (t *Template) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return err
+	}
+	t.Template, t.source = parsed, s
+	return nil
+}
+
+func (t Template) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.source)
+}
+
+// render executes the template with data, removing surrounding whitespace and
+// truncating the result to at most limit characters.
+func (t *Template) render(data interface{}, limit int) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return truncate(strings.TrimSpace(b.String()), limit), nil
+}
+
+// truncate shortens s to at most limit characters, ending it with an ellipsis
+// if anything was cut.
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:limit-1])) + "…"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRender(t *testing.T) {
+	r := require.New(t)
+
+	var tmpl Template
+	r.NoError(json.Unmarshal([]byte(`"  {{upper .}} "`), &tmpl))
+	out, err := tmpl.render("dinner", ynabMemoMaxLength)
+	r.NoError(err)
+	r.Equal("DINNER", out)
+
+	out, err = tmpl.render(strings.Repeat("a", 250), ynabMemoMaxLength)
+	r.NoError(err)
+	r.Equal(ynabMemoMaxLength, len([]rune(out)))
+	r.True(strings.HasSuffix(out, "A…"))
+
+	data, err := json.Marshal(tmpl)
+	r.NoError(err)
+	r.Equal(`"  {{upper .}} "`, string(data))
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "café", truncate("café", 4))
+	require.Equal(t, "caf…", truncate("cafés", 4))
+	require.Equal(t, "a…", truncate("a bc", 3), "the ellipsis follows the last word")
+}
+
+func TestTemplatesValidatedOnLoad(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+access_token: token
+providers:
+  splitwise:
+    account_id: account
+    options:
+      user_id: 123
+      memo_template: "{{.Description}} {{.Notes}}"
+`)
+	_, err := loadConfig(path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "providers.splitwise.options.memo_template: ")
+	require.Contains(t, err.Error(), "can't evaluate field Notes")
+
+	path = writeConfig(t, "config.yaml", `
+access_token: token
+providers:
+  splitwise:
+    account_id: account
+    options:
+      user_id: 123
+      memo_template: "{{.Description}}{{with .Group}} ({{.}}){{end}}"
+      payee_template: "{{index .Participants 1}}"
+`)
+	_, err = loadConfig(path)
+	require.NoError(t, err)
+}
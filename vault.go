@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+)
+
+// The environment variable checked for the vault passphrase when none is configured.
+const vaultPassphraseEnv = "BUDGETBRIDGE_VAULT_PASSPHRASE"
+
+// ErrSecretNotFound is returned when a vault has no entry with the requested name.
+var ErrSecretNotFound = errors.New("secret not found")
+
+type VaultConfig struct {
+	// The path of the vault file. If empty, no vault is used.
+	Path string `json:"path"`
+	// The passphrase protecting the vault. If empty, it is read from the
+	// environment or prompted for.
+	Passphrase string `json:"passphrase"`
+}
+
+func (vc VaultConfig) passphrase() ([]byte, error) {
+	if vc.Passphrase != "" {
+		return []byte(vc.Passphrase), nil
+	}
+	if p, ok := os.LookupEnv(vaultPassphraseEnv); ok {
+		return []byte(p), nil
+	}
+	if !isTerminal(os.Stdin) {
+		return nil, fmt.Errorf("no passphrase configured, set %s or run interactively", vaultPassphraseEnv)
+	}
+	return readPassword(fmt.Sprintf("passphrase for %s: ", vc.Path))
+}
+
+// Vault is a file of named secrets encrypted with a key derived from a passphrase.
+//
+// The file is decrypted the first time it is accessed, so that no passphrase is
+// needed unless the vault is actually used. Every modification is written to
+// disk immediately.
+type Vault struct {
+	Path       string
+	Passphrase func() ([]byte, error)
+
+	mu      sync.Mutex
+	loaded  bool
+	salt    []byte
+	key     []byte
+	entries map[string]string
+}
+
+// vaultFile is the on-disk format of a Vault.
+type vaultFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+const (
+	vaultVersion = 1
+	vaultKeySize = 32
+	// scrypt parameters recommended for interactive logins.
+	vaultScryptN = 1 << 15
+	vaultScryptR = 8
+	vaultScryptP = 1
+)
+
+func (v *Vault) Get(name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.load(); err != nil {
+		return "", err
+	}
+	value, ok := v.entries[name]
+	if !ok {
+		return "", fmt.Errorf("%w: '%s'", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+func (v *Vault) Set(name, value string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.load(); err != nil {
+		return err
+	}
+	v.entries[name] = value
+	return v.save()
+}
+
+func (v *Vault) Delete(name string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.load(); err != nil {
+		return err
+	}
+	if _, ok := v.entries[name]; !ok {
+		return fmt.Errorf("%w: '%s'", ErrSecretNotFound, name)
+	}
+	delete(v.entries, name)
+	return v.save()
+}
+
+// Names returns the names of all entries in sorted order.
+func (v *Vault) Names() ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.load(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(v.entries))
+	for name := range v.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (v *Vault) load() error {
+	if v.loaded {
+		return nil
+	}
+	passphrase, err := v.Passphrase()
+	if err != nil {
+		return fmt.Errorf("vault passphrase: %s", err)
+	}
+	data, err := ioutil.ReadFile(v.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		// A new vault, which will be created on the first write.
+		v.salt = make([]byte, 16)
+		if _, err := rand.Read(v.salt); err != nil {
+			return err
+		}
+		if v.key, err = deriveVaultKey(passphrase, v.salt); err != nil {
+			return err
+		}
+		v.entries = make(map[string]string)
+		v.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var vf vaultFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return fmt.Errorf("decode vault %s: %s", v.Path, err)
+	}
+	if vf.Version != vaultVersion {
+		return fmt.Errorf("vault %s: unsupported version %d", v.Path, vf.Version)
+	}
+	key, err := deriveVaultKey(passphrase, vf.Salt)
+	if err != nil {
+		return err
+	}
+	aead, err := newVaultAEAD(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, vf.Nonce, vf.Ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("vault %s: incorrect passphrase or corrupt file", v.Path)
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return fmt.Errorf("decode vault %s: %s", v.Path, err)
+	}
+	v.salt = vf.Salt
+	v.key = key
+	v.entries = entries
+	v.loaded = true
+	return nil
+}
+
+func (v *Vault) save() error {
+	plaintext, err := json.Marshal(v.entries)
+	if err != nil {
+		return err
+	}
+	aead, err := newVaultAEAD(v.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	vf := vaultFile{
+		Version:    vaultVersion,
+		Salt:       v.salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	}
+	return writeFileAtomic(v.Path, 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(&vf)
+	})
+}
+
+func deriveVaultKey(passphrase, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, vaultScryptN, vaultScryptR, vaultScryptP, vaultKeySize)
+}
+
+func newVaultAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// VaultTokenStore implements a TokenStore that keeps the token in a Vault.
+type VaultTokenStore struct {
+	Vault *Vault
+	Name  string
+}
+
+func (vs *VaultTokenStore) Load() (*oauth2.Token, error) {
+	value, err := vs.Vault.Get(vs.Name)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(value), &token); err != nil {
+		return nil, fmt.Errorf("decode token '%s': %s", vs.Name, err)
+	}
+	return &token, nil
+}
+
+func (vs *VaultTokenStore) Save(token *oauth2.Token) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return vs.Vault.Set(vs.Name, string(value))
+}
+
+type vaultContextKey struct{}
+
+// withVault returns a context carrying the configured vault, if any.
+func withVault(ctx context.Context, v *Vault) context.Context {
+	return context.WithValue(ctx, vaultContextKey{}, v)
+}
+
+func vaultFromContext(ctx context.Context) *Vault {
+	v, _ := ctx.Value(vaultContextKey{}).(*Vault)
+	return v
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestVaultRoundTrip(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	passphrase := func(p string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(p), nil }
+	}
+
+	v := &Vault{Path: path, Passphrase: passphrase("correct horse")}
+	r.NoError(v.Set("client_secret", "s3cret"))
+	store := &VaultTokenStore{Vault: v, Name: "splitwise_token"}
+	r.NoError(store.Save(&oauth2.Token{AccessToken: "access"}))
+
+	reopened := &Vault{Path: path, Passphrase: passphrase("correct horse")}
+	value, err := reopened.Get("client_secret")
+	r.NoError(err)
+	r.Equal("s3cret", value)
+	names, err := reopened.Names()
+	r.NoError(err)
+	r.Equal([]string{"client_secret", "splitwise_token"}, names)
+	token, err := (&VaultTokenStore{Vault: reopened, Name: "splitwise_token"}).Load()
+	r.NoError(err)
+	r.Equal("access", token.AccessToken)
+
+	_, err = reopened.Get("missing")
+	r.True(errors.Is(err, ErrSecretNotFound))
+
+	wrong := &Vault{Path: path, Passphrase: passphrase("battery staple")}
+	_, err = wrong.Get("client_secret")
+	r.Error(err)
+}
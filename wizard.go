@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"budgetbridge/splitwise"
+	"budgetbridge/ynab"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultLookBackDays        = 30
+	defaultSplitwiseTokenCache = ".splitwise.token"
+	ynabAccessTokenEnv         = "YNAB_ACCESS_TOKEN"
+	splitwiseClientSecretEnv   = "SPLITWISE_CLIENT_SECRET"
+)
+
+func runInit(ctx context.Context, cmd *command, args []string) error {
+	fs, configPath := newFlagSet(cmd)
+	force := fs.Bool("force", false, "overwrite an existing config file without asking")
+	mode := fs.String("mode", "server", "how to receive the Splitwise authorization code: 'server' or 'manual'")
+	if args = parseArgs(fs, args); len(args) > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	if _, err := configFormatFor(*configPath); err != nil {
+		return err
+	}
+	if *mode != "server" && *mode != "manual" {
+		return fmt.Errorf("unknown mode '%s'", *mode)
+	}
+
+	w := &initWizard{
+		prompter: newPrompter(os.Stdin, os.Stdout),
+		mode:     *mode,
+	}
+	if _, err := os.Stat(*configPath); err == nil && !*force {
+		overwrite, err := w.confirm(fmt.Sprintf("%s already exists, overwrite it?", *configPath), false)
+		if err != nil || !overwrite {
+			return err
+		}
+	}
+	tree, err := w.run(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := formatConfig(*configPath, tree)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(*configPath, 0600, func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write config: %s", err)
+	}
+	fmt.Printf("\nWrote %s.\n", *configPath)
+
+	// Load the result back to make sure that it's usable as written.
+	for name, value := range w.env {
+		os.Setenv(name, value)
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("generated config is invalid: %s", err)
+	}
+	checker := &configChecker{client: w.ynab, out: os.Stdout}
+	checker.check(ctx, config)
+	if checker.problems > 0 {
+		return fmt.Errorf("found %d problem(s) in %s", checker.problems, *configPath)
+	}
+	if len(w.env) > 0 {
+		fmt.Println("\nSet these environment variables before running budgetbridge:")
+		names := make([]string, 0, len(w.env))
+		for name := range w.env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	return nil
+}
+
+// initWizard asks the questions needed to build a config, looking up the
+// available choices from YNAB and Splitwise as it goes.
+type initWizard struct {
+	*prompter
+	mode string
+
+	ynab *ynab.Client
+	// Whether secrets are read from the environment rather than the config.
+	useEnv bool
+	// The values of the environment variables the config refers to.
+	env map[string]string
+}
+
+// secret stores a secret value under key in the config, either directly or as
+// a reference to an environment variable.
+func (w *initWizard) secret(tree map[string]interface{}, key, envName, value string) {
+	if !w.useEnv {
+		tree[key] = value
+		return
+	}
+	tree[key+secretEnvSuffix] = envName
+	w.env[envName] = value
+}
+
+func (w *initWizard) run(ctx context.Context) (map[string]interface{}, error) {
+	storage, err := w.choose("Where should secrets be read from?", []string{
+		"the config file",
+		"environment variables",
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	w.useEnv = storage == 1
+	w.env = make(map[string]string)
+
+	tree := map[string]interface{}{
+		"lookback_days": defaultLookBackDays,
+		"cache": map[string]interface{}{
+			"dir":                ".cache",
+			"create_missing_dir": true,
+		},
+	}
+
+	fmt.Fprintln(w.out, "\nCreate a personal access token under Account Settings > Developer Settings in YNAB.")
+	token, err := w.askSecret("YNAB personal access token")
+	if err != nil {
+		return nil, err
+	}
+	w.secret(tree, "access_token", ynabAccessTokenEnv, token)
+	w.ynab = ynab.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: token,
+	})))
+
+	budget, err := w.chooseBudget(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tree["budget_id"] = budget.Id
+	account, err := w.chooseAccount(ctx, budget.Id)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := w.ynab.Categories(ctx, ynab.CategoriesRequest{BudgetID: budget.Id})
+	if err != nil {
+		return nil, fmt.Errorf("get categories: %s", err)
+	}
+
+	options, err := w.splitwiseOptions(ctx, categories)
+	if err != nil {
+		return nil, err
+	}
+	tree["providers"] = map[string]interface{}{
+		"splitwise": map[string]interface{}{
+			"account_id": account.Id,
+			"options":    options,
+		},
+	}
+	return tree, nil
+}
+
+func (w *initWizard) chooseBudget(ctx context.Context) (ynab.BudgetSummary, error) {
+	res, err := w.ynab.Budgets(ctx)
+	if err != nil {
+		return ynab.BudgetSummary{}, fmt.Errorf("get budgets: %s", err)
+	}
+	if len(res.Budgets) == 0 {
+		return ynab.BudgetSummary{}, fmt.Errorf("there are no budgets in this YNAB account")
+	}
+	def := 0
+	names := make([]string, len(res.Budgets))
+	for i, b := range res.Budgets {
+		names[i] = b.Name
+		if res.DefaultBudget != nil && b.Id == res.DefaultBudget.Id {
+			def = i
+		}
+	}
+	fmt.Fprintln(w.out, "\nBudgets:")
+	i, err := w.choose("Budget to import into", names, def)
+	if err != nil {
+		return ynab.BudgetSummary{}, err
+	}
+	return res.Budgets[i], nil
+}
+
+func (w *initWizard) chooseAccount(ctx context.Context, budgetID string) (ynab.Account, error) {
+	res, err := w.ynab.Accounts(ctx, budgetID)
+	if err != nil {
+		return ynab.Account{}, fmt.Errorf("get accounts: %s", err)
+	}
+	var accounts []ynab.Account
+	var names []string
+	for _, a := range res.Accounts {
+		if a.Closed || a.Deleted {
+			continue
+		}
+		accounts = append(accounts, a)
+		names = append(names, a.Name)
+	}
+	if len(accounts) == 0 {
+		return ynab.Account{}, fmt.Errorf("there are no open accounts in this budget")
+	}
+	fmt.Fprintln(w.out, "\nAccounts:")
+	i, err := w.choose("Account that tracks your Splitwise balance", names, -1)
+	if err != nil {
+		return ynab.Account{}, err
+	}
+	return accounts[i], nil
+}
+
+func (w *initWizard) splitwiseOptions(ctx context.Context, categories ynab.CategoriesResponse) (map[string]interface{}, error) {
+	fmt.Fprintln(w.out, "\nRegister an application at https://secure.splitwise.com/apps with the callback URL")
+	fmt.Fprintf(w.out, "%s.\n", defaultSplitwiseRedirectURL)
+	key, err := w.askRequired("Splitwise consumer key")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := w.askSecret("Splitwise consumer secret")
+	if err != nil {
+		return nil, err
+	}
+	tokenCache, err := w.ask("File to store the Splitwise token in", defaultSplitwiseTokenCache)
+	if err != nil {
+		return nil, err
+	}
+	sw := &SplitwiseOptions{
+		ClientKey:    key,
+		ClientSecret: secret,
+		TokenCache:   tokenCache,
+	}
+	client, err := w.authorizeSplitwise(ctx, sw)
+	if err != nil {
+		return nil, err
+	}
+	user, err := client.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get_current_user: %s", err)
+	}
+	res, err := client.GetCategories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get_categories: %s", err)
+	}
+	mapping, err := w.mapCategories(splitwiseCategoryChoices(res), categories)
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]interface{}{
+		"user_id":          user.ID,
+		"client_key":       key,
+		"token_cache":      tokenCache,
+		"category_mapping": mapping,
+	}
+	w.secret(options, "client_secret", splitwiseClientSecretEnv, secret)
+	return options, nil
+}
+
+// authorizeSplitwise runs the oauth flow and stores the resulting token.
+func (w *initWizard) authorizeSplitwise(ctx context.Context, options *SplitwiseOptions) (*splitwise.Client, error) {
+	store, err := options.tokenStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig := options.oauthConfig()
+	var source oauth2.TokenSource
+	if w.mode == "manual" {
+		source = &ManualTokenSource{
+			Config: *oauthConfig,
+			In:     w.in,
+			Out:    w.out,
+		}
+	} else {
+		source = &LocalServerTokenSource{
+			Config:  *oauthConfig,
+			Timeout: defaultAuthTimeout,
+		}
+	}
+	token, err := source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("authorize splitwise: %s", err)
+	}
+	if err := store.Save(token); err != nil {
+		return nil, fmt.Errorf("store token: %s", err)
+	}
+	fmt.Fprintln(w.out, "splitwise authorized successfully.")
+	return splitwise.NewClient(oauthConfig.Client(ctx, token)), nil
+}
+
+// splitwiseCategoryChoice is a Splitwise subcategory that can be mapped, by
+// its ID and its path such as "Utilities / Electricity".
+type splitwiseCategoryChoice struct {
+	ID   int
+	Path string
+}
+
+func (c splitwiseCategoryChoice) String() string {
+	return c.Path
+}
+
+// splitwiseCategoryChoices lists every subcategory in res. Subcategories which
+// share a name under different parents, such as "Other", are listed apart.
+func splitwiseCategoryChoices(res *splitwise.GetCategoriesResponse) []splitwiseCategoryChoice {
+	var choices []splitwiseCategoryChoice
+	for _, c := range res.Categories {
+		for _, sub := range c.Subcategories {
+			choices = append(choices, splitwiseCategoryChoice{
+				ID:   sub.ID,
+				Path: c.Name + splitwiseCategoryPathSep + sub.Name,
+			})
+		}
+	}
+	return choices
+}
+
+// mapCategories asks for the YNAB category of each Splitwise category,
+// returning the category mapping entries for those that were mapped.
+func (w *initWizard) mapCategories(
+	choices []splitwiseCategoryChoice,
+	res ynab.CategoriesResponse,
+) ([]map[string]interface{}, error) {
+	index := newCategoryIndex(res.CategoryGroups)
+	categories := index.Categories()
+	labels := make([]string, len(categories))
+	for i, c := range categories {
+		labels[i] = index.QualifiedName(c.Id)
+	}
+
+	fmt.Fprintln(w.out, "\nYNAB categories:")
+	w.list(labels)
+	fmt.Fprintln(w.out, "Choose the YNAB category for each Splitwise category, or leave it empty to skip it.")
+	mapping := make([]map[string]interface{}, 0, len(choices))
+	for _, choice := range choices {
+		i, err := w.askIndex(choice.String(), len(categories), -1, true)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			continue
+		}
+		// Entries are keyed by the ID of the Splitwise category, with its
+		// path to describe it. YNAB names are only qualified by their group
+		// when they'd otherwise be ambiguous.
+		mapping = append(mapping, map[string]interface{}{
+			"id":        choice.ID,
+			"name":      choice.Path,
+			"ynab_name": index.UniqueName(categories[i].Id),
+		})
+	}
+	return mapping, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"budgetbridge/splitwise"
+	"budgetbridge/ynab"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatConfigRoundTrip(t *testing.T) {
+	tree := map[string]interface{}{
+		"budget_id":     "budget",
+		"access_token":  "token",
+		"lookback_days": defaultLookBackDays,
+		"cache": map[string]interface{}{
+			"dir":                ".cache",
+			"create_missing_dir": true,
+		},
+		"providers": map[string]interface{}{
+			"splitwise": map[string]interface{}{
+				"account_id": "account",
+				"options": map[string]interface{}{
+					"user_id":     123,
+					"client_key":  "key",
+					"token_cache": ".splitwise.token",
+					"category_mapping": []map[string]interface{}{
+						{"name": "Groceries", "ynab_name": "Food"},
+						{"name": "Rent", "ynab_id": "rent-id"},
+					},
+				},
+			},
+		},
+	}
+	for _, name := range []string{"config.json", "config.toml", "config.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+			path := filepath.Join(t.TempDir(), name)
+			data, err := formatConfig(path, tree)
+			r.NoError(err)
+			r.NoError(ioutil.WriteFile(path, data, 0600))
+
+			config, err := loadConfig(path)
+			r.NoError(err)
+			r.Equal("budget", *config.BudgetID)
+			r.Equal("token", config.AccessToken)
+			r.Equal(int64(defaultLookBackDays), config.LookBackDays)
+			r.True(config.Cache.CreateMissingDir)
+
+			provider := config.Providers.Map["splitwise"]
+			r.Equal("account", provider.AccountID)
+			options := provider.Options.(*SplitwiseOptions)
+			r.Equal(123, *options.UserID)
+			r.Equal(CategoryMapping{
+				"Groceries": {Name: "Groceries", YnabName: "Food"},
+				"Rent":      {Name: "Rent", YnabId: "rent-id"},
+			}, options.CategoryMapping)
+		})
+	}
+}
+
+func TestInitWizardMapCategories(t *testing.T) {
+	r := require.New(t)
+
+	choices := splitwiseCategoryChoices(&splitwise.GetCategoriesResponse{
+		Categories: []splitwise.Category{
+			{Name: "Food and drink", Subcategories: []splitwise.Subcategory{
+				{ID: 12, Name: "Groceries"},
+				{ID: 25, Name: "Other"},
+			}},
+			{Name: "Utilities", Subcategories: []splitwise.Subcategory{
+				{ID: 11, Name: "Electricity"},
+				{ID: 26, Name: "Other"},
+			}},
+		},
+	})
+	r.Equal([]splitwiseCategoryChoice{
+		{ID: 12, Path: "Food and drink / Groceries"},
+		{ID: 25, Path: "Food and drink / Other"},
+		{ID: 11, Path: "Utilities / Electricity"},
+		{ID: 26, Path: "Utilities / Other"},
+	}, choices)
+
+	categories := ynab.CategoriesResponse{
+		CategoryGroups: []ynab.CategoryGroup{
+			{Name: "Everyday", Categories: []ynab.Category{
+				{Id: "groceries", Name: "Groceries"},
+				{Id: "old", Name: "Old", Hidden: true},
+				{Id: "misc-1", Name: "Misc"},
+			}},
+			{Name: "Bills", Categories: []ynab.Category{
+				{Id: "misc-2", Name: "Misc"},
+			}},
+		},
+	}
+	// Groceries -> Everyday: Groceries, the first Other is skipped,
+	// Electricity -> Bills: Misc, which has to be qualified by its group, and
+	// the second Other -> Everyday: Misc.
+	var out bytes.Buffer
+	w := &initWizard{prompter: newPrompter(strings.NewReader("1\n\nx\n3\n2\n"), &out)}
+	mapping, err := w.mapCategories(choices, categories)
+	r.NoError(err)
+	r.Equal([]map[string]interface{}{
+		{"id": 12, "name": "Food and drink / Groceries", "ynab_name": "Groceries"},
+		{"id": 11, "name": "Utilities / Electricity", "ynab_name": "Bills: Misc"},
+		{"id": 26, "name": "Utilities / Other", "ynab_name": "Everyday: Misc"},
+	}, mapping)
+	r.Contains(out.String(), "  2) Everyday: Misc\n")
+	r.Contains(out.String(), "enter a number between 1 and 3\n")
+}
@@ -0,0 +1,8 @@
+package endpoint
+
+import "golang.org/x/oauth2"
+
+var Endpoint = oauth2.Endpoint{
+	AuthURL:  "https://app.youneedabudget.com/oauth/authorize",
+	TokenURL: "https://app.youneedabudget.com/oauth/token",
+}
@@ -73,6 +73,10 @@ type TransactionsResponse struct {
 	DuplicateImportIDs []string      `json:"duplicate_import_ids"`
 }
 
+type TransactionResponse struct {
+	Transaction Transaction `json:"transaction"`
+}
+
 type CategoriesRequest struct {
 	BudgetID string
 }
@@ -133,6 +137,8 @@ func (d *Date) MarshalJSON() ([]byte, error) {
 }
 
 type Transaction struct {
+	// Id is only set on transactions returned by the API.
+	Id         string  `json:"id,omitempty"`
 	AccountId  string  `json:"account_id"`
 	Date       Date    `json:"date"`
 	Amount     int     `json:"amount"`
@@ -142,9 +148,69 @@ type Transaction struct {
 	Memo       string  `json:"memo"`
 	ImportId   *string `json:"import_id,omitempty"`
 	Approved   bool    `json:"approved"`
-	// TODO
-	// Cleared
-	FlagColor *string `json:"flag_color,omitempty"`
+	// Cleared is left to YNAB, which treats new transactions as uncleared,
+	// if it's empty.
+	Cleared   ClearedStatus `json:"cleared,omitempty"`
+	FlagColor *FlagColor    `json:"flag_color,omitempty"`
+	Deleted   bool          `json:"deleted,omitempty"`
+	// Subtransactions split the amount of the transaction. Their amounts
+	// must add up to it.
+	Subtransactions []SubTransaction `json:"subtransactions,omitempty"`
+}
+
+// A SubTransaction is a part of a split transaction.
+type SubTransaction struct {
+	Amount     int     `json:"amount"`
+	PayeeName  string  `json:"payee_name,omitempty"`
+	CategoryId *string `json:"category_id,omitempty"`
+	Memo       string  `json:"memo,omitempty"`
+}
+
+// ClearedStatus is whether a transaction has cleared the account.
+type ClearedStatus string
+
+const (
+	Cleared    ClearedStatus = "cleared"
+	Uncleared  ClearedStatus = "uncleared"
+	Reconciled ClearedStatus = "reconciled"
+)
+
+// ClearedStatuses are all the valid cleared statuses.
+var ClearedStatuses = []ClearedStatus{Cleared, Uncleared, Reconciled}
+
+// Valid reports whether the status is one YNAB knows.
+func (s ClearedStatus) Valid() bool {
+	for _, status := range ClearedStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagColor is the color a transaction is flagged with.
+type FlagColor string
+
+const (
+	FlagRed    FlagColor = "red"
+	FlagOrange FlagColor = "orange"
+	FlagYellow FlagColor = "yellow"
+	FlagGreen  FlagColor = "green"
+	FlagBlue   FlagColor = "blue"
+	FlagPurple FlagColor = "purple"
+)
+
+// FlagColors are all the colors a transaction may be flagged with.
+var FlagColors = []FlagColor{FlagRed, FlagOrange, FlagYellow, FlagGreen, FlagBlue, FlagPurple}
+
+// Valid reports whether the color is one YNAB knows.
+func (c FlagColor) Valid() bool {
+	for _, color := range FlagColors {
+		if c == color {
+			return true
+		}
+	}
+	return false
 }
 
 type AccountsResponse struct {
@@ -238,6 +304,16 @@ func (c *Client) CreateTransactions(ctx context.Context, budgetID string, reques
 	return
 }
 
+func (c *Client) DeleteTransaction(ctx context.Context, budgetID, transactionID string) (response TransactionResponse, err error) {
+	u := fmt.Sprintf("budgets/%s/transactions/%s", budgetID, transactionID)
+	req, err := c.newRequest(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return
+	}
+	err = c.do(req, &response)
+	return
+}
+
 func (c *Client) Categories(ctx context.Context, request CategoriesRequest) (response CategoriesResponse, err error) {
 	u := fmt.Sprintf("budgets/%s/categories", request.BudgetID)
 	req, err := c.newRequest(ctx, http.MethodGet, u, &request)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"budgetbridge/ynab"
+	ynabEndpoint "budgetbridge/ynab/endpoint"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultYNABRedirectURL = "http://localhost:4000/auth_redirect"
+
+// YNABOAuth configures access to YNAB through its oauth2 authorization code
+// flow, as an alternative to a personal access token.
+type YNABOAuth struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURL  string `json:"redirect_url"`
+	TokenCache   string `json:"token_cache"`
+}
+
+func (yo *YNABOAuth) oauthConfig() *oauth2.Config {
+	redirectURL := yo.RedirectURL
+	if redirectURL == "" {
+		redirectURL = defaultYNABRedirectURL
+	}
+	return &oauth2.Config{
+		ClientID:     yo.ClientID,
+		ClientSecret: yo.ClientSecret,
+		Endpoint:     ynabEndpoint.Endpoint,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func (yo *YNABOAuth) tokenStore(ctx context.Context) (TokenStore, error) {
+	return newTokenStore(ctx, yo.TokenCache)
+}
+
+// newYNABClient creates a client using either the configured personal access
+// token or oauth2 client credentials.
+func newYNABClient(ctx context.Context, config Config) (*ynab.Client, error) {
+	var ts oauth2.TokenSource
+	switch {
+	case config.AccessToken != "" && config.OAuth != nil:
+		return nil, fmt.Errorf("only one of access_token or oauth may be configured")
+	case config.AccessToken != "":
+		ts = oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: config.AccessToken,
+		})
+	case config.OAuth != nil:
+		store, err := config.OAuth.tokenStore(ctx)
+		if err != nil {
+			return nil, err
+		}
+		oauthConfig := config.OAuth.oauthConfig()
+		ts = &CachingTokenSource{
+			Name:   "ynab",
+			Store:  store,
+			Config: oauthConfig,
+			Authorize: &LocalServerTokenSource{
+				Config:  *oauthConfig,
+				Timeout: defaultAuthTimeout,
+			},
+			NonInteractive: !isTerminal(os.Stdin),
+		}
+	default:
+		return nil, fmt.Errorf("either access_token or oauth must be configured")
+	}
+	return ynab.NewClient(oauth2.NewClient(ctx, ts)), nil
+}
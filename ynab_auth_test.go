@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewYNABClient(t *testing.T) {
+	v := &Vault{
+		Path:       filepath.Join(t.TempDir(), "secrets.vault"),
+		Passphrase: func() ([]byte, error) { return []byte("correct horse"), nil },
+	}
+	for _, tc := range []struct {
+		name   string
+		vault  *Vault
+		config Config
+		err    string
+	}{
+		{
+			name:   "access token",
+			config: Config{AccessToken: "token"},
+		},
+		{
+			name:   "oauth",
+			config: Config{OAuth: &YNABOAuth{ClientID: "id", TokenCache: filepath.Join(t.TempDir(), "ynab.token")}},
+		},
+		{
+			name:   "oauth with a vault token cache",
+			vault:  v,
+			config: Config{OAuth: &YNABOAuth{ClientID: "id", TokenCache: "vault:ynab_token"}},
+		},
+		{
+			name:   "oauth with a vault token cache but no vault",
+			config: Config{OAuth: &YNABOAuth{ClientID: "id", TokenCache: "vault:ynab_token"}},
+			err:    "token cache 'vault:ynab_token' refers to the vault, but no vault is configured",
+		},
+		{
+			name:   "both",
+			config: Config{AccessToken: "token", OAuth: &YNABOAuth{ClientID: "id"}},
+			err:    "only one of access_token or oauth may be configured",
+		},
+		{
+			name: "neither",
+			err:  "either access_token or oauth must be configured",
+		},
+	} {
+		ctx := context.Background()
+		if tc.vault != nil {
+			ctx = withVault(ctx, tc.vault)
+		}
+		client, err := newYNABClient(ctx, tc.config)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.NotNil(t, client, tc.name)
+	}
+}